@@ -0,0 +1,154 @@
+package grpcp
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type authNameKey struct{}
+
+// prefixes of the names returned by authName, to tell token names from common names
+const (
+	authTokenPrefix = "token:"
+	authCertPrefix  = "cn:"
+)
+
+// Token is a named bearer token for client authentication.
+type Token struct {
+	Name  string
+	Value string
+}
+
+// LoadTokens loads tokens from the file.
+// Each line of the file is "name:token" or "token". Empty lines and lines starting with "#" are ignored.
+func LoadTokens(filename string) ([]Token, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open token file: %w", err)
+	}
+	defer f.Close()
+	var tokens []Token
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			name, value = fmt.Sprintf("token%d", len(tokens)+1), line
+		}
+		if value == "" {
+			return nil, fmt.Errorf("empty token for %s in %s", name, filename)
+		}
+		tokens = append(tokens, Token{Name: name, Value: value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens found in %s", filename)
+	}
+	return tokens, nil
+}
+
+type authenticator struct {
+	tokens []Token
+}
+
+func (a *authenticator) authenticate(ctx context.Context, method string) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing metadata")
+	}
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "missing authorization token")
+	}
+	value, found := strings.CutPrefix(values[0], "Bearer ")
+	if !found {
+		return nil, status.Error(codes.Unauthenticated, "invalid authorization scheme")
+	}
+	for _, token := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(value), []byte(token.Value)) == 1 {
+			slog.Debug("authenticated", "method", method, "name", token.Name)
+			return context.WithValue(ctx, authNameKey{}, token.Name), nil
+		}
+	}
+	slog.Warn("authentication failed", "method", method)
+	return nil, status.Error(codes.Unauthenticated, "invalid authorization token")
+}
+
+func (a *authenticator) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *authenticator) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authServerStream{ServerStream: ss, ctx: ctx})
+}
+
+type authServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// authName returns the name of the token used by the client as "token:NAME",
+// or the common name of the client certificate as "cn:NAME".
+func authName(ctx context.Context) string {
+	if name, ok := ctx.Value(authNameKey{}).(string); ok {
+		return authTokenPrefix + name
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+			return authCertPrefix + info.State.VerifiedChains[0][0].Subject.CommonName
+		}
+	}
+	return ""
+}
+
+// checkAdminNames checks that the admin names are qualified like the names returned by authName.
+func checkAdminNames(names []string) error {
+	for _, name := range names {
+		if !strings.HasPrefix(name, authTokenPrefix) && !strings.HasPrefix(name, authCertPrefix) {
+			return fmt.Errorf("admin name must be %sNAME or %sNAME: %s", authTokenPrefix, authCertPrefix, name)
+		}
+	}
+	return nil
+}
+
+// tokenCredentials sends the bearer token as per-RPC credentials.
+type tokenCredentials struct {
+	token string
+}
+
+func (c tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.token}, nil
+}
+
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
@@ -0,0 +1,113 @@
+package grpcp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	caCertFilename = "ca.crt"
+	caKeyFilename  = "ca.key"
+)
+
+// InitCA generates a CA certificate and private key as ca.crt and ca.key in dir.
+func InitCA(dir, name string, validity time.Duration, force bool) error {
+	certFile, keyFile := filepath.Join(dir, caCertFilename), filepath.Join(dir, caKeyFilename)
+	if err := checkOverwrite(force, certFile, keyFile); err != nil {
+		return err
+	}
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate private key: %w", err)
+	}
+	template, err := newCertTemplate(name, nil, validity)
+	if err != nil {
+		return err
+	}
+	template.IsCA = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := writeKeyPair(certFile, keyFile, certDER, priv); err != nil {
+		return err
+	}
+	slog.Info("generated CA certificate", "cert", certFile, "key", keyFile, "fingerprint", certFingerprint(certDER))
+	return nil
+}
+
+// IssueServerCert issues a server certificate for hosts signed by the CA in dir,
+// and writes it as <name>.crt and <name>.key in dir.
+func IssueServerCert(dir, name string, hosts []string, validity time.Duration, force bool) error {
+	if len(hosts) == 0 {
+		return fmt.Errorf("no hosts specified for the server certificate")
+	}
+	return issueCert(dir, name, hosts[0], hosts, x509.ExtKeyUsageServerAuth, validity, force)
+}
+
+// IssueClientCert issues a client certificate with the common name signed by the CA in dir,
+// and writes it as <name>.crt and <name>.key in dir.
+func IssueClientCert(dir, name string, validity time.Duration, force bool) error {
+	return issueCert(dir, name, name, nil, x509.ExtKeyUsageClientAuth, validity, force)
+}
+
+func issueCert(dir, name, commonName string, hosts []string, usage x509.ExtKeyUsage, validity time.Duration, force bool) error {
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := checkOverwrite(force, certFile, keyFile); err != nil {
+		return err
+	}
+	ca, err := tls.LoadX509KeyPair(filepath.Join(dir, caCertFilename), filepath.Join(dir, caKeyFilename))
+	if err != nil {
+		return fmt.Errorf("failed to load CA key pair (run init-ca first): %w", err)
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate private key: %w", err)
+	}
+	template, err := newCertTemplate(commonName, hosts, validity)
+	if err != nil {
+		return err
+	}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+	if template.NotAfter.After(caCert.NotAfter) {
+		template.NotAfter = caCert.NotAfter
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, &priv.PublicKey, ca.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %w", err)
+	}
+	if err := writeKeyPair(certFile, keyFile, certDER, priv); err != nil {
+		return err
+	}
+	slog.Info("issued certificate", "cert", certFile, "key", keyFile, "common_name", commonName, "hosts", hosts)
+	return nil
+}
+
+func checkOverwrite(force bool, files ...string) error {
+	if force {
+		return nil
+	}
+	for _, f := range files {
+		if fileExists(f) {
+			return fmt.Errorf("%s already exists. use --force to overwrite", f)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package grpcp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumXXHash = "xxhash"
+	ChecksumBLAKE3 = "blake3"
+	ChecksumNone   = "none"
+)
+
+// checksumAlgorithm returns the checksum algorithm to be used by the client.
+// SHA-256 is used by default.
+func checksumAlgorithm(algo string) string {
+	if algo == "" {
+		return ChecksumSHA256
+	}
+	return algo
+}
+
+// newHash returns a hash.Hash for the algorithm.
+// It returns nil if the algorithm is empty or "none".
+func newHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case "", ChecksumNone:
+		return nil, nil
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumXXHash:
+		return xxhash.New(), nil
+	case ChecksumBLAKE3:
+		return blake3.New(), nil
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported checksum algorithm: %s", algo)
+	}
+}
+
+// hashPrefix writes the first n bytes of r into h.
+func hashPrefix(h hash.Hash, r io.ReaderAt, n int64) error {
+	if h == nil || n == 0 {
+		return nil
+	}
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, n)); err != nil {
+		return fmt.Errorf("failed to read file for checksum: %w", err)
+	}
+	return nil
+}
+
+func sum(h hash.Hash) []byte {
+	if h == nil {
+		return nil
+	}
+	return h.Sum(nil)
+}
+
+// verifyChecksum compares the checksum calculated by the receiver with the one sent by the sender.
+func verifyChecksum(h hash.Hash, received []byte) error {
+	if h == nil {
+		return nil
+	}
+	if received == nil {
+		return status.Error(codes.DataLoss, "checksum was not received")
+	}
+	if calculated := h.Sum(nil); !bytes.Equal(calculated, received) {
+		return status.Errorf(codes.DataLoss, "checksum mismatch: expected %x, got %x", received, calculated)
+	}
+	return nil
+}
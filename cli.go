@@ -3,7 +3,13 @@ package grpcp
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/alecthomas/kong"
 )
@@ -18,41 +24,181 @@ type CLI struct {
 	Debug bool `name:"debug" short:"d" help:"enable debug log"`
 	TLS   bool `name:"tls" negatable:"" default:"true" help:"enable TLS (default: true)"`
 
-	Server bool   `name:"server" short:"s" help:"run as server"`
-	Cert   string `name:"cert" help:"certificate file for server" type:"existingfile"`
-	Key    string `name:"key" help:"private key file for server" type:"existingfile"`
+	Server             bool          `name:"server" short:"s" help:"run as server"`
+	Cert               string        `name:"cert" help:"certificate file for server" type:"existingfile"`
+	Key                string        `name:"key" help:"private key file for server" type:"existingfile"`
+	CertReloadInterval time.Duration `name:"cert-reload-interval" default:"1m" help:"interval to check the certificate files for reloading. 0 disables checking (SIGHUP always reloads)"`
+	CertDir            string        `name:"cert-dir" help:"directory to store the generated certificate for server (default: the config directory)" type:"path"`
+	CertHost           []string      `name:"cert-host" help:"host names and IP addresses to be included in the generated certificate, in addition to --host"`
+	ClientCA           string        `name:"client-ca" help:"CA certificate file to verify client certificates. clients are required to present a certificate" type:"existingfile"`
+	Root               string        `name:"root" help:"root directory for server. all file paths are resolved relative to it" type:"existingdir"`
+	TokenFile          string        `name:"token-file" env:"GRPCP_TOKEN_FILE" help:"token file. server requires clients to send one of the tokens, client sends the first token" type:"existingfile"`
+	Token              string        `name:"token" env:"GRPCP_TOKEN" help:"token for client authentication"`
+	AllowShutdown      bool          `name:"allow-remote-shutdown" help:"allow admins to shut down the server by --kill"`
+	Admin              []string      `name:"admin" help:"admins to allow remote shutdown. token:NAME for token names, cn:NAME for client certificate common names"`
+	ConnLimitRate      ByteSize      `name:"conn-limit-rate" help:"bandwidth limit of each client connection for server in bytes per second (e.g. 10M)"`
+	ShutdownTimeout    time.Duration `name:"shutdown-timeout" default:"30s" help:"time to wait for the active transfers on shutdown, then cancel them. 0 waits indefinitely"`
+	PreserveOwner      bool          `name:"preserve-owner" help:"apply the owner and group of the files transferred with --preserve, uploaded files for server and downloaded files for client. requires the privilege to change the owner"`
 
-	VerifyTLSCert bool `name:"verify-tls-cert" default:"false" help:"TLS verification for client"`
-	Kill          bool `name:"kill" help:"send shutdown command to server"`
-	Ping          bool `name:"ping" help:"send ping message to server"`
+	VerifyTLSCert   bool          `name:"verify-tls-cert" default:"false" help:"TLS verification for client"`
+	TOFU            bool          `name:"tofu" negatable:"" default:"true" help:"pin the server certificate fingerprint on first use when TLS verification is disabled (default: true)"`
+	KnownHosts      string        `name:"known-hosts" help:"known hosts file to pin server certificate fingerprints (default: known_hosts in the config directory)" type:"path"`
+	CACert          string        `name:"ca-cert" help:"CA certificate file to verify the server certificate. implies --verify-tls-cert" type:"existingfile"`
+	ServerName      string        `name:"server-name" help:"server name to verify the server certificate (default: host name)"`
+	ClientCert      string        `name:"client-cert" help:"client certificate file for mutual TLS" type:"existingfile"`
+	ClientKey       string        `name:"client-key" help:"client private key file for mutual TLS" type:"existingfile"`
+	Recursive       bool          `name:"recursive" short:"r" help:"copy directories recursively"`
+	Preserve        bool          `name:"preserve" help:"preserve mode, modification and access times, and ownership (if permitted) of files like scp -p (-p is --port)"`
+	Resume          bool          `name:"resume" help:"resume the transfer from the size of the partially transferred file"`
+	Checksum        string        `name:"checksum" enum:"sha256,xxhash,blake3,none" default:"sha256" help:"checksum algorithm to verify the transferred content (sha256, xxhash, blake3, none)"`
+	Parallel        int           `name:"parallel" default:"1" help:"number of concurrent streams to transfer a large file in ranges"`
+	Connections     int           `name:"connections" default:"1" help:"number of connections to the server to distribute the streams over"`
+	LimitRate       ByteSize      `name:"limit-rate" help:"bandwidth limit in bytes per second (e.g. 20M). for server, the limit of all transfers"`
+	Compress        string        `name:"compress" enum:"none,gzip,zstd,snappy,auto" default:"none" help:"compress the transferred content (none, gzip, zstd, snappy, auto). auto uses the best codec supported by the server and skips incompressible data"`
+	Retry           int           `name:"retry" default:"0" help:"number of retries on temporary failures such as network errors. retries resume the transfer"`
+	RetryBackoff    time.Duration `name:"retry-backoff" default:"1s" help:"time to wait before the first retry. doubled on each retry"`
+	RetryMaxBackoff time.Duration `name:"retry-max-backoff" default:"30s" help:"maximum time to wait between retries"`
+	Kill            bool          `name:"kill" help:"send shutdown command to server"`
+	Ping            bool          `name:"ping" help:"send ping message to server"`
 
-	Src  string `arg:"" optional:"" name:"src" short:"s" description:"source file path"`
-	Dest string `arg:"" optional:"" name:"dest" short:"d" description:"destination file path"`
+	Copy    CopyCmd  `cmd:"" default:"withargs" help:"copy files (default command). write a local file named like a command as ./name"`
+	Ls      LsCmd    `cmd:"" name:"ls" help:"list files on the remote host"`
+	Rm      RmCmd    `cmd:"" name:"rm" help:"remove files on the remote host"`
+	Mkdir   MkdirCmd `cmd:"" name:"mkdir" help:"create directories on the remote host"`
+	Mv      MvCmd    `cmd:"" name:"mv" help:"rename a file on the remote host"`
+	CertCmd CertCmd  `cmd:"" name:"cert" help:"manage certificates for TLS"`
 }
 
-func (c *CLI) ClientOption() *ClientOption {
-	return &ClientOption{
-		Host:       c.Host,
-		Port:       c.Port,
-		Quiet:      c.Quiet,
-		TLS:        c.TLS,
-		SkipVerify: !c.VerifyTLSCert,
+type CopyCmd struct {
+	Paths []string `arg:"" optional:"" name:"src" help:"source paths followed by the destination path. the destination of multiple sources must be a directory"`
+}
+
+type LsCmd struct {
+	Path string `arg:"" name:"path" help:"remote path (host:/path)"`
+	Long bool   `name:"long" short:"l" help:"list in long format"`
+}
+
+type RmCmd struct {
+	Paths []string `arg:"" name:"path" help:"remote paths (host:/path). use --recursive to remove directories"`
+}
+
+type MkdirCmd struct {
+	Paths   []string `arg:"" name:"path" help:"remote paths (host:/path)"`
+	Parents bool     `name:"parents" help:"create parent directories as needed"`
+}
+
+type MvCmd struct {
+	Src  string `arg:"" name:"src" help:"remote path (host:/path)"`
+	Dest string `arg:"" name:"dest" help:"destination path on the same host"`
+}
+
+type CertCmd struct {
+	Dir   string `name:"dir" default:"." help:"directory of the CA and issued certificates" type:"path"`
+	Days  int    `name:"days" help:"validity period in days (default: 3650 for CA, 365 for others)"`
+	Force bool   `name:"force" help:"overwrite existing files"`
+
+	InitCA      CertInitCACmd      `cmd:"" name:"init-ca" help:"generate a CA certificate and private key (ca.crt, ca.key)"`
+	IssueServer CertIssueServerCmd `cmd:"" name:"issue-server" help:"issue a server certificate for --host and --cert-host (<name>.crt, <name>.key)"`
+	IssueClient CertIssueClientCmd `cmd:"" name:"issue-client" help:"issue a client certificate (<name>.crt, <name>.key)"`
+}
+
+type CertInitCACmd struct {
+	Name string `name:"name" default:"grpcp CA" help:"common name of the CA certificate"`
+}
+
+type CertIssueServerCmd struct {
+	Name string `name:"name" default:"server" help:"base name of the certificate files"`
+}
+
+type CertIssueClientCmd struct {
+	Name string `name:"name" required:"" help:"client name. used for the common name and the base name of the certificate files"`
+}
+
+func (c *CertCmd) validity(defaultDays int) time.Duration {
+	days := c.Days
+	if days <= 0 {
+		days = defaultDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func (c *CLI) ClientOption() (*ClientOption, error) {
+	token := c.Token
+	if token == "" && c.TokenFile != "" {
+		tokens, err := LoadTokens(c.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = tokens[0].Value
 	}
+	skipVerify := !c.VerifyTLSCert && c.CACert == ""
+	var knownHosts string
+	if skipVerify && c.TOFU {
+		knownHosts = c.KnownHosts
+		if dir := DefaultConfigDir(); knownHosts == "" && dir != "" {
+			knownHosts = filepath.Join(dir, "known_hosts")
+		}
+	}
+	return &ClientOption{
+		Host:           c.Host,
+		Port:           c.Port,
+		Quiet:          c.Quiet,
+		TLS:            c.TLS,
+		SkipVerify:     skipVerify,
+		Resume:         c.Resume,
+		Checksum:       c.Checksum,
+		Recursive:      c.Recursive,
+		Preserve:       c.Preserve,
+		PreserveOwner:  c.PreserveOwner,
+		Token:          token,
+		CertFile:       c.ClientCert,
+		KeyFile:        c.ClientKey,
+		CACertFile:     c.CACert,
+		ServerName:     c.ServerName,
+		KnownHostsFile: knownHosts,
+		Compress:       c.Compress,
+		LimitRate:      int64(c.LimitRate),
+		Parallel:       c.Parallel,
+		Connections:    c.Connections,
+		Retry: RetryPolicy{
+			MaxAttempts:    c.Retry + 1,
+			InitialBackoff: c.RetryBackoff,
+			MaxBackoff:     c.RetryMaxBackoff,
+			Multiplier:     2,
+			Jitter:         0.2,
+		},
+	}, nil
 }
 
 func (c *CLI) ServerOption() *ServerOption {
+	certDir := c.CertDir
+	if certDir == "" && (c.Cert == "" || c.Key == "") {
+		certDir = DefaultConfigDir()
+	}
 	return &ServerOption{
-		Port:     c.Port,
-		Listen:   c.Host,
-		TLS:      c.TLS,
-		CertFile: c.Cert,
-		KeyFile:  c.Key,
+		Port:                c.Port,
+		Listen:              c.Host,
+		TLS:                 c.TLS,
+		CertFile:            c.Cert,
+		KeyFile:             c.Key,
+		Root:                c.Root,
+		TokenFile:           c.TokenFile,
+		ClientCAFile:        c.ClientCA,
+		CertDir:             certDir,
+		CertHosts:           c.CertHost,
+		CertReloadInterval:  c.CertReloadInterval,
+		AllowRemoteShutdown: c.AllowShutdown,
+		AdminNames:          c.Admin,
+		ShutdownTimeout:     c.ShutdownTimeout,
+		RateLimit:           int64(c.LimitRate),
+		ConnRateLimit:       int64(c.ConnLimitRate),
+		PreserveOwner:       c.PreserveOwner,
 	}
 }
 
 func RunCLI(ctx context.Context) error {
 	cli := &CLI{}
-	kong.Parse(cli)
+	kctx := kong.Parse(cli)
 
 	if cli.Quiet {
 		slog.SetLogLoggerLevel(slog.LevelWarn)
@@ -62,10 +208,56 @@ func RunCLI(ctx context.Context) error {
 		slog.SetLogLoggerLevel(slog.LevelInfo)
 	}
 
-	client := NewClient(cli.ClientOption())
-	switch {
-	case cli.Server:
+	switch kctx.Command() {
+	case "cert init-ca":
+		return InitCA(cli.CertCmd.Dir, cli.CertCmd.InitCA.Name, cli.CertCmd.validity(3650), cli.CertCmd.Force)
+	case "cert issue-server":
+		hosts := certHosts(cli.ServerOption())
+		return IssueServerCert(cli.CertCmd.Dir, cli.CertCmd.IssueServer.Name, hosts, cli.CertCmd.validity(365), cli.CertCmd.Force)
+	case "cert issue-client":
+		return IssueClientCert(cli.CertCmd.Dir, cli.CertCmd.IssueClient.Name, cli.CertCmd.validity(365), cli.CertCmd.Force)
+	}
+
+	if cli.Server {
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
 		return RunServer(ctx, cli.ServerOption())
+	}
+	opt, err := cli.ClientOption()
+	if err != nil {
+		return err
+	}
+	client := NewClient(opt)
+	var command string
+	if node := kctx.Selected(); node != nil {
+		command = node.Name
+	}
+	switch command {
+	case "ls":
+		entries, err := client.List(ctx, cli.Ls.Path)
+		if err != nil {
+			return err
+		}
+		printList(os.Stdout, entries, cli.Ls.Long)
+		return nil
+	case "rm":
+		for _, p := range cli.Rm.Paths {
+			if err := client.Remove(ctx, p, cli.Recursive); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "mkdir":
+		for _, p := range cli.Mkdir.Paths {
+			if err := client.Mkdir(ctx, p, cli.Mkdir.Parents); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "mv":
+		return client.Rename(ctx, cli.Mv.Src, cli.Mv.Dest)
+	}
+	switch {
 	case cli.Ping:
 		resp, err := client.Ping(ctx)
 		if err != nil {
@@ -75,9 +267,24 @@ func RunCLI(ctx context.Context) error {
 		return nil
 	case cli.Kill:
 		return client.Shutdown(ctx)
-	case cli.Src != "" && cli.Dest != "":
-		return client.Copy(ctx, cli.Src, cli.Dest)
+	case len(cli.Copy.Paths) >= 2:
+		n := len(cli.Copy.Paths)
+		return client.CopyFiles(ctx, cli.Copy.Paths[:n-1], cli.Copy.Paths[n-1])
 	default:
-		return fmt.Errorf("expected: grpcp <src> <dest> or grpcp --server. see --help")
+		return fmt.Errorf("expected: grpcp <src> ... <dest> or grpcp --server. see --help")
+	}
+}
+
+func printList(w io.Writer, entries []*FileInfo, long bool) {
+	for _, e := range entries {
+		name := e.Name
+		if e.IsDir() {
+			name += "/"
+		}
+		if long {
+			fmt.Fprintf(w, "%s %12d %s %s\n", e.Mode, e.Size, e.ModTime.Format("2006-01-02 15:04:05"), name)
+		} else {
+			fmt.Fprintln(w, name)
+		}
 	}
 }
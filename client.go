@@ -2,20 +2,24 @@ package grpcp
 
 import (
 	"context"
-	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 
 	pb "github.com/fujiwara/grpcp/proto"
 	"github.com/schollz/progressbar/v3"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 func uploadFile(ctx context.Context, client pb.FileTransferServiceClient, remoteFile, localFile string, opt *ClientOption) error {
@@ -28,47 +32,126 @@ func uploadFile(ctx context.Context, client pb.FileTransferServiceClient, remote
 	if err != nil {
 		return fmt.Errorf("failed to stat file: %w", err)
 	}
+	if st.IsDir() {
+		return status.Errorf(codes.InvalidArgument, "%s is a directory (use --recursive to copy directories)", localFile)
+	}
 
 	// if remoteFile is directory, use localFile's basename
 	if strings.HasSuffix(remoteFile, "/") {
 		remoteFile = filepath.Join(remoteFile, filepath.Base(localFile))
 	}
 
+	// parallel transfer is not resumable, so resuming falls back to a single stream
+	if opt.Parallel > 1 && !opt.Resume {
+		if ranges := splitRanges(st.Size(), opt.Parallel); ranges != nil {
+			return uploadParallel(ctx, client, remoteFile, file, st, ranges, opt)
+		}
+	}
+
+	var offset int64
+	if opt.Resume {
+		res, err := client.Stat(ctx, &pb.StatRequest{Filename: remoteFile, Partial: true})
+		if err != nil {
+			return fmt.Errorf("failed to stat remote file: %w", err)
+		}
+		if res.Exists && res.Size <= st.Size() {
+			offset = res.Size
+		} else if res.Exists {
+			slog.Warn("remote partial file is larger than local file. upload from the beginning", "remote", remoteFile, "bytes", res.Size)
+		}
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek file: %w", err)
+		}
+	}
+
+	algo := checksumAlgorithm(opt.Checksum)
+	h, err := newHash(algo)
+	if err != nil {
+		return err
+	}
+	if err := hashPrefix(h, file, offset); err != nil {
+		return err
+	}
+	var r io.Reader = file
+	if h != nil {
+		r = io.TeeReader(file, h)
+	}
+	var compressions []string
+	if opt.Compress != "" && opt.Compress != CompressionNone {
+		if compressions, err = serverCompressions(ctx, client); err != nil {
+			return err
+		}
+	}
+	c, err := newCompressor(opt.Compress, compressions)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	limiter := newLimiter(opt.LimitRate)
+
 	stream, err := client.Upload(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to new upload stream: %w", err)
 	}
-	slog.Info("staring upload", "local", localFile, "remote", remoteFile, "bytes", st.Size())
+	slog.Info("staring upload", "local", localFile, "remote", remoteFile, "bytes", st.Size(), "offset", offset)
 	var bar io.Writer
 	if opt.Quiet {
 		bar = io.Discard
 	} else {
-		bar = progressbar.DefaultBytes(st.Size(), "uploading")
+		pbar := progressbar.DefaultBytes(st.Size(), "uploading")
+		pbar.Set64(offset)
+		bar = pbar
 	}
 	expectedBytes := st.Size()
-	var totalBytes int64
+	totalBytes := offset
+	sent := false
 	buf := make([]byte, StreamBufferSize)
 	for {
-		n, err := file.Read(buf)
+		req := &pb.FileUploadRequest{
+			Filename: remoteFile,
+			Size:     expectedBytes,
+		}
+		if !sent {
+			req.Offset = offset
+			req.ChecksumAlgorithm = algo
+			req.Resume = opt.keepPartial()
+			if opt.Preserve {
+				req.Metadata = newMetadata(st)
+			}
+		}
+		n, err := r.Read(buf)
 		if err == io.EOF {
 			slog.Info("client upload completed", "bytes", totalBytes)
 			if totalBytes != expectedBytes {
-				return fmt.Errorf("file size mismatch: expected %d bytes, got %d bytes", st.Size(), totalBytes)
+				return status.Errorf(codes.DataLoss, "file size mismatch: expected %d bytes, got %d bytes", st.Size(), totalBytes)
+			}
+			if sent && h == nil {
+				break
+			}
+			// send the last message with the checksum, or create the file if nothing was sent
+			req.Checksum = sum(h)
+			if err := stream.Send(req); err != nil && err != io.EOF {
+				return fmt.Errorf("failed to send file: %w", err)
 			}
 			break
 		} else if err != nil {
 			return fmt.Errorf("failed to read file: %w", err)
 		}
 
-		req := &pb.FileUploadRequest{
-			Filename: remoteFile,
-			Content:  buf[:n],
-			Size:     expectedBytes,
+		if req.Content, req.Compression, err = c.compress(buf[:n]); err != nil {
+			return err
+		}
+		sent = true
+		if err := waitLimiters(ctx, len(req.Content), limiter); err != nil {
+			return err
 		}
-		if err := stream.Send(req); err != nil {
+		if err := stream.Send(req); err == io.EOF {
+			// the server closed the stream. the actual error is returned by CloseAndRecv
+			break
+		} else if err != nil {
 			return fmt.Errorf("failed to send file: %w", err)
 		}
-		bar.Write(req.Content)
+		bar.Write(buf[:n])
 		totalBytes += int64(n)
 	}
 
@@ -81,54 +164,121 @@ func uploadFile(ctx context.Context, client pb.FileTransferServiceClient, remote
 }
 
 func downloadFile(ctx context.Context, client pb.FileTransferServiceClient, remoteFile, localFile string, opt *ClientOption) error {
+	// if localFile is directory, use remoteFile's basename
+	if st, err := os.Stat(localFile); err == nil && st.IsDir() {
+		localFile = filepath.Join(localFile, filepath.Base(remoteFile))
+	}
+
+	if opt.Parallel > 1 && !opt.Resume {
+		res, err := client.Stat(ctx, &pb.StatRequest{Filename: remoteFile})
+		if err != nil {
+			return fmt.Errorf("failed to stat remote file: %w", err)
+		}
+		if ranges := splitRanges(res.Size, opt.Parallel); res.Exists && !res.IsDir && ranges != nil {
+			return downloadParallel(ctx, client, remoteFile, localFile, res.Size, ranges, opt)
+		}
+	}
+
+	var offset int64
+	if opt.Resume {
+		if st, err := os.Stat(partialFilename(localFile)); err == nil {
+			res, err := client.Stat(ctx, &pb.StatRequest{Filename: remoteFile})
+			if err != nil {
+				return fmt.Errorf("failed to stat remote file: %w", err)
+			}
+			if res.Exists && st.Size() <= res.Size {
+				offset = st.Size()
+			} else if res.Exists {
+				slog.Warn("local partial file is larger than remote file. download from the beginning", "local", localFile, "bytes", st.Size())
+			}
+		}
+	}
+
+	algo := checksumAlgorithm(opt.Checksum)
+	h, err := newHash(algo)
+	if err != nil {
+		return err
+	}
 	stream, err := client.Download(ctx, &pb.FileDownloadRequest{
-		Filename: remoteFile,
+		Filename:          remoteFile,
+		Offset:            offset,
+		ChecksumAlgorithm: algo,
+		Preserve:          opt.Preserve,
+		Compression:       opt.Compress,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to new download stream: %w", err)
 	}
 
-	// if localFile is directory, use remoteFile's basename
-	if st, err := os.Stat(localFile); err == nil && st.IsDir() {
-		localFile = filepath.Join(localFile, filepath.Base(remoteFile))
+	// the file is created after the first response, so a failed request leaves nothing
+	res, err := stream.Recv()
+	if err == io.EOF {
+		return status.Error(codes.DataLoss, "no response received")
+	} else if err != nil {
+		return fmt.Errorf("failed to receive response: %w", err)
 	}
-
-	f, err := os.OpenFile(localFile, os.O_WRONLY|os.O_CREATE, 0644)
+	f, err := createAtomicFile(localFile, offset, opt.keepPartial())
 	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+		return err
+	}
+	defer f.Abort()
+	if err := hashPrefix(h, f, offset); err != nil {
+		return err
+	}
+	var w io.Writer = f
+	if h != nil {
+		w = io.MultiWriter(f, h)
+	}
+	if !opt.Quiet {
+		bar := progressbar.DefaultBytes(res.Size, "downloading")
+		bar.Set64(offset)
+		w = io.MultiWriter(w, bar)
 	}
-	defer f.Close()
+	d := &decompressor{}
+	defer d.Close()
+	limiter := newLimiter(opt.LimitRate)
 
-	slog.Info("staring download", "remote", remoteFile, "local", localFile)
+	slog.Info("staring download", "remote", remoteFile, "local", localFile, "offset", offset)
 
-	var once sync.Once
-	var w io.Writer
-	var expectedBytes, totalBytes int64
+	expectedBytes := res.Size
+	var checksum []byte
+	var metadata *pb.FileMetadata
+	totalBytes := offset
 	for {
-		res, err := stream.Recv()
+		if res.Checksum != nil {
+			checksum = res.Checksum
+		}
+		if res.Metadata != nil {
+			metadata = res.Metadata
+		}
+		if err := waitLimiters(ctx, len(res.Content), limiter); err != nil {
+			return err
+		}
+		content, err := d.decompress(res.Content, res.Compression)
+		if err != nil {
+			return err
+		}
+		if n, err := w.Write(content); err != nil {
+			return fmt.Errorf("failed to write file: %w", err)
+		} else {
+			totalBytes += int64(n)
+		}
+		res, err = stream.Recv()
 		if err == io.EOF {
 			slog.Info("client download completed", "bytes", totalBytes)
 			if totalBytes != expectedBytes {
-				return fmt.Errorf("file size mismatch: expected %d bytes, got %d bytes", expectedBytes, totalBytes)
+				return status.Errorf(codes.DataLoss, "file size mismatch: expected %d bytes, got %d bytes", expectedBytes, totalBytes)
+			}
+			if err := verifyChecksum(h, checksum); err != nil {
+				return err
+			}
+			if err := applyMetadata(f.File, metadata, opt.PreserveOwner); err != nil {
+				return err
 			}
-			return nil
+			return f.Commit()
 		} else if err != nil {
 			return fmt.Errorf("failed to receive response: %w", err)
 		}
-		once.Do(func() {
-			expectedBytes = res.Size
-			if opt.Quiet {
-				w = f
-			} else {
-				bar := progressbar.DefaultBytes(res.Size, "downloading")
-				w = io.MultiWriter(f, bar)
-			}
-		})
-		if n, err := w.Write(res.Content); err != nil {
-			return fmt.Errorf("failed to write file: %w", err)
-		} else {
-			totalBytes += int64(n)
-		}
 	}
 }
 
@@ -155,38 +305,146 @@ func (c *Client) Ping(ctx context.Context) (*pb.PingResponse, error) {
 }
 
 func (c *Client) Copy(ctx context.Context, src, dest string) error {
-	var transfer transferFunc
-	var remoteHost, remoteFile, localFile string
+	if srcHost, _ := parseFilename(src); srcHost != "" {
+		if destHost, _ := parseFilename(dest); destHost != "" {
+			return c.relay(ctx, src, dest)
+		}
+	}
+	transfer, remoteHost, remoteFile, localFile, err := c.resolveCopy(src, dest)
+	if err != nil {
+		return err
+	}
+
+	client, close, err := c.newGRPCClient(c.addr(remoteHost))
+	if err != nil {
+		return err
+	}
+	defer close()
+
+	return transfer(ctx, client, remoteFile, localFile, c.Option)
+}
 
+// CopyFiles copies multiple sources into the dest directory like cp, over a single connection.
+// All the sources must be local for uploading, or on the same remote host for downloading.
+// A failure of a source does not stop copying the others, and the failures are returned together.
+func (c *Client) CopyFiles(ctx context.Context, srcs []string, dest string) error {
+	if len(srcs) == 1 {
+		return c.Copy(ctx, srcs[0], dest)
+	}
+	type copyJob struct {
+		src        string
+		transfer   transferFunc
+		remoteFile string
+		localFile  string
+	}
+	var remoteHost string
+	jobs := make([]copyJob, 0, len(srcs))
+	names := make(map[string]string, len(srcs))
+	for _, src := range srcs {
+		transfer, host, remoteFile, localFile, err := c.resolveCopy(src, dest)
+		if err != nil {
+			return err
+		}
+		// sources in the different directories may have the same name, which would overwrite each other.
+		// the names matched by glob patterns are checked by downloadGlob
+		if _, srcFile := parseFilename(src); !hasGlobMeta(srcFile) {
+			name := path.Base(filepath.ToSlash(srcFile))
+			if prev, ok := names[name]; ok {
+				return status.Errorf(codes.InvalidArgument, "%s has the same name as %s", src, prev)
+			}
+			names[name] = src
+		}
+		if remoteHost != "" && c.addr(host) != c.addr(remoteHost) {
+			return status.Errorf(codes.InvalidArgument, "all remote sources must be on the same host: %s", src)
+		}
+		remoteHost = host
+		jobs = append(jobs, copyJob{src: src, transfer: transfer, remoteFile: remoteFile, localFile: localFile})
+	}
+
+	client, close, err := c.newGRPCClient(c.addr(remoteHost))
+	if err != nil {
+		return err
+	}
+	defer close()
+
+	// like cp, the destination of multiple sources must be an existing directory
+	destHost, destFile := parseFilename(dest)
+	if destHost != "" {
+		var res *pb.StatResponse
+		err := c.Option.Retry.do(ctx, func(error) (err error) {
+			res, err = client.Stat(ctx, &pb.StatRequest{Filename: destFile})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to stat remote file: %w", err)
+		}
+		if !res.Exists || !res.IsDir {
+			return status.Errorf(codes.InvalidArgument, "destination must be a directory to copy multiple files: %s", dest)
+		}
+	} else if st, err := os.Stat(destFile); err != nil || !st.IsDir() {
+		return status.Errorf(codes.InvalidArgument, "destination must be a directory to copy multiple files: %s", dest)
+	}
+
+	var errs []error
+	for _, job := range jobs {
+		remoteFile := job.remoteFile
+		if destHost != "" && !strings.HasSuffix(remoteFile, "/") {
+			// upload into the directory
+			remoteFile += "/"
+		}
+		if err := job.transfer(ctx, client, remoteFile, job.localFile, c.Option); err != nil {
+			slog.Error("failed to copy", "src", job.src, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", job.src, err))
+			continue
+		}
+		slog.Info("copied", "src", job.src, "dest", dest)
+	}
+	slog.Info("copy completed", "sources", len(jobs), "errors", len(errs))
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to copy %d of %d sources: %w", len(errs), len(jobs), errors.Join(errs...))
+	}
+	return nil
+}
+
+// resolveCopy returns the transfer function for copying src to dest, and the remote host and paths.
+func (c *Client) resolveCopy(src, dest string) (transfer transferFunc, remoteHost, remoteFile, localFile string, err error) {
 	srcHost, srcFile := parseFilename(src)
 	destHost, destFile := parseFilename(dest)
 	if srcHost != "" && destHost != "" {
-		return fmt.Errorf("both src and dest are remote")
+		// a single source is relayed by Copy
+		return nil, "", "", "", status.Error(codes.InvalidArgument, "copying multiple sources between remote hosts is not supported")
 	}
 	if srcHost != "" && destHost == "" {
 		// remote to local (download)
-		transfer = downloadFile
-		remoteHost = srcHost
-		remoteFile = srcFile
-		localFile = destFile
+		transfer = retryTransfer(downloadFile)
+		if c.Option.Recursive {
+			transfer = downloadDir
+		}
+		if hasGlobMeta(srcFile) {
+			transfer = downloadGlob
+		}
+		if destFile == stdioPath {
+			if c.Option.Recursive {
+				return nil, "", "", "", status.Error(codes.InvalidArgument, "cannot copy directories to stdout")
+			}
+			transfer = downloadStdout
+		}
+		return transfer, srcHost, srcFile, destFile, nil
 	} else if srcHost == "" && destHost != "" {
 		// local to remote (upload)
-		transfer = uploadFile
-		remoteHost = destHost
-		remoteFile = destFile
-		localFile = srcFile
-	} else {
-		return fmt.Errorf("both src and dest are local")
-	}
-
-	addr := fmt.Sprintf("%s:%d", remoteHost, c.Option.Port)
-	client, close, err := c.newGRPCClient(addr)
-	if err != nil {
-		return err
+		transfer = retryTransfer(uploadFile)
+		if c.Option.Recursive {
+			transfer = uploadDir
+		}
+		if srcFile == stdioPath {
+			if c.Option.Recursive {
+				return nil, "", "", "", status.Error(codes.InvalidArgument, "cannot copy directories from stdin")
+			}
+			transfer = uploadStdin
+		}
+		return transfer, destHost, destFile, srcFile, nil
 	}
-	defer close()
-
-	return transfer(ctx, client, remoteFile, localFile, c.Option)
+	return nil, "", "", "", fmt.Errorf("both src and dest are local")
 }
 
 func (c *Client) Shutdown(ctx context.Context) error {
@@ -204,26 +462,80 @@ func (c *Client) Shutdown(ctx context.Context) error {
 func (c *Client) newGRPCClient(addr string) (pb.FileTransferServiceClient, func() error, error) {
 	opts := []grpc.DialOption{}
 	if c.Option.TLS {
-		tlsConfig := &tls.Config{
-			InsecureSkipVerify: c.Option.SkipVerify,
+		tlsConfig, err := genClientTLS(c.Option, addr)
+		if err != nil {
+			return nil, nil, err
 		}
 		creds := credentials.NewTLS(tlsConfig)
 		opts = append(opts, grpc.WithTransportCredentials(creds))
 	} else {
 		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	}
-	conn, err := grpc.NewClient(addr, opts...)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to dial server: %w", err)
+	if c.Option.Token != "" {
+		if !c.Option.TLS {
+			slog.Warn("sending the token without TLS")
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{token: c.Option.Token}))
+	}
+	pool := &connPool{}
+	for i := 0; i < max(c.Option.Connections, 1); i++ {
+		conn, err := grpc.NewClient(addr, opts...)
+		if err != nil {
+			pool.Close()
+			return nil, nil, fmt.Errorf("failed to dial server: %w", err)
+		}
+		pool.conns = append(pool.conns, conn)
 	}
-	client := pb.NewFileTransferServiceClient(conn)
-	return client, conn.Close, nil
+	client := pb.NewFileTransferServiceClient(pool)
+	return client, pool.Close, nil
+}
+
+// connPool distributes the RPCs over the connections in round robin,
+// so that parallel streams are not bound by a single connection.
+type connPool struct {
+	conns []*grpc.ClientConn
+	next  atomic.Uint32
 }
 
+func (p *connPool) pick() *grpc.ClientConn {
+	return p.conns[int(p.next.Add(1)-1)%len(p.conns)]
+}
+
+func (p *connPool) Invoke(ctx context.Context, method string, args any, reply any, opts ...grpc.CallOption) error {
+	return p.pick().Invoke(ctx, method, args, reply, opts...)
+}
+
+func (p *connPool) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return p.pick().NewStream(ctx, desc, method, opts...)
+}
+
+func (p *connPool) Close() error {
+	var errs []error
+	for _, conn := range p.conns {
+		errs = append(errs, conn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// parseFilename splits "host:/path" into the host and the path.
+// The host may have the port like "host:port:/path", which overrides --port.
 func parseFilename(filename string) (string, string) {
 	p := strings.SplitN(filename, ":", 2)
 	if len(p) == 1 {
 		return "", p[0] // local
 	}
+	if port, rest, ok := strings.Cut(p[1], ":"); ok && strings.HasPrefix(rest, "/") {
+		if n, err := strconv.Atoi(port); err == nil && n > 0 {
+			return p[0] + ":" + port, rest // remote with port
+		}
+	}
 	return p[0], p[1] // remote
 }
+
+// addr returns the address of the host, which is "host" or "host:port" returned by parseFilename.
+func (c *Client) addr(host string) string {
+	if strings.Contains(host, ":") {
+		return host
+	}
+	return fmt.Sprintf("%s:%d", host, c.Option.Port)
+}
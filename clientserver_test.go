@@ -4,18 +4,31 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/fujiwara/grpcp"
+	pb "github.com/fujiwara/grpcp/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 var (
 	testPortFrom = 18022
 	testHost     = "127.0.0.1"
+	testRootPort = testPortFrom + 2
+	testRoot     string
+	testAuthPort = testPortFrom + 3
+	testToken    = "secret-token"
 )
 
 func testPort(tls bool) int {
@@ -26,24 +39,31 @@ func testPort(tls bool) int {
 }
 
 func runServer(tls bool) {
-	ctx := context.Background()
-	opt := &grpcp.ServerOption{
+	runServerWithOption(&grpcp.ServerOption{
 		Port:   testPort(tls),
 		Listen: testHost,
 		TLS:    tls,
-	}
+	})
+}
+
+func runServerWithOption(opt *grpcp.ServerOption) {
+	runServerWithClientOption(opt, &grpcp.ClientOption{
+		Port:       opt.Port,
+		Host:       testHost,
+		TLS:        opt.TLS,
+		SkipVerify: true,
+	})
+}
+
+func runServerWithClientOption(opt *grpcp.ServerOption, clientOpt *grpcp.ClientOption) {
+	ctx := context.Background()
 	go func() {
 		err := grpcp.RunServer(context.Background(), opt)
 		if err != nil {
 			panic("failed to run grpcp server:" + err.Error())
 		}
 	}()
-	client := grpcp.NewClient(&grpcp.ClientOption{
-		Port:       testPort(tls),
-		Host:       testHost,
-		TLS:        tls,
-		SkipVerify: true,
-	})
+	client := grpcp.NewClient(clientOpt)
 	for i := 0; i < 3; i++ {
 		_, err := client.Ping(ctx)
 		if err == nil {
@@ -67,7 +87,32 @@ func TestMain(m *testing.M) {
 	grpcp.StreamBufferSize = 4096 // for test
 	runServer(false)
 	runServer(true)
+
+	var err error
+	testRoot, err = os.MkdirTemp("", "grpcp-root")
+	if err != nil {
+		panic(err)
+	}
+	runServerWithOption(&grpcp.ServerOption{
+		Port:   testRootPort,
+		Listen: testHost,
+		Root:   testRoot,
+	})
+	tokenFile := filepath.Join(testRoot, "tokens")
+	if err := os.WriteFile(tokenFile, []byte("# test tokens\nteam-a:"+testToken+"\nteam-b:other-token\n"), 0600); err != nil {
+		panic(err)
+	}
+	runServerWithClientOption(&grpcp.ServerOption{
+		Port:      testAuthPort,
+		Listen:    testHost,
+		TokenFile: tokenFile,
+	}, &grpcp.ClientOption{
+		Port:  testAuthPort,
+		Host:  testHost,
+		Token: testToken,
+	})
 	exitVal := m.Run()
+	os.RemoveAll(testRoot)
 	os.Exit(exitVal)
 }
 
@@ -145,3 +190,1378 @@ func TestRemoteToLocal(t *testing.T) {
 		})
 	}
 }
+
+func TestRoot(t *testing.T) {
+	dir := t.TempDir()
+	testLocal := filepath.Join(dir, "local.txt")
+	content := generateRandomBytes(t)
+	if err := os.WriteFile(testLocal, content, 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	if err := os.Symlink(dir, filepath.Join(testRoot, "outside")); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+	defer os.Remove(filepath.Join(testRoot, "outside"))
+
+	client := grpcp.NewClient(&grpcp.ClientOption{
+		Port:  testRootPort,
+		Quiet: true,
+	})
+	ctx := context.Background()
+
+	// upload into the root directory
+	if err := client.Copy(ctx, testLocal, testHost+":/uploaded.txt"); err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	remoteContent, err := os.ReadFile(filepath.Join(testRoot, "uploaded.txt"))
+	if err != nil {
+		t.Fatalf("failed to read remote file: %s", err)
+	}
+	if !bytes.Equal(content, remoteContent) {
+		t.Fatalf("content mismatch: expected %d bytes, got %d bytes", len(content), len(remoteContent))
+	}
+
+	// download from the root directory
+	testDownload := filepath.Join(dir, "downloaded.txt")
+	if err := client.Copy(ctx, testHost+":uploaded.txt", testDownload); err != nil {
+		t.Fatalf("failed to download: %s", err)
+	}
+
+	// the error messages do not reveal the root directory of the server
+	err = client.Copy(ctx, testHost+":/missing.txt", filepath.Join(dir, "missing.txt"))
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("download missing file: expected NotFound, got %v", err)
+	} else if msg := err.Error(); strings.Contains(msg, testRoot) || !strings.Contains(msg, "/missing.txt") {
+		t.Errorf("unexpected error message: %s", msg)
+	}
+	err = client.Copy(ctx, testLocal, testHost+":/missing/new.txt")
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("upload into missing directory: expected NotFound, got %v", err)
+	} else if strings.Contains(err.Error(), testRoot) {
+		t.Errorf("unexpected error message: %s", err)
+	}
+
+	// the root itself cannot be written, because its temporary files are created outside of the root
+	conn, err := grpc.NewClient(
+		fmt.Sprintf("%s:%d", testHost, testRootPort),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer conn.Close()
+	pbClient := pb.NewFileTransferServiceClient(conn)
+	parent, base := filepath.Dir(testRoot), filepath.Base(testRoot)
+	for _, name := range []string{".", "/"} {
+		for _, req := range []*pb.FileUploadRequest{
+			{Filename: name, Content: []byte("evil"), Size: 4},
+			{Filename: name, Content: []byte("evil"), Size: 4, Resume: true},
+			{Filename: name, Content: []byte("evil"), Size: 8, Length: 4},
+		} {
+			stream, err := pbClient.Upload(ctx)
+			if err != nil {
+				t.Fatalf("failed to upload: %s", err)
+			}
+			stream.Send(req)
+			if _, err := stream.CloseAndRecv(); status.Code(err) != codes.PermissionDenied {
+				t.Errorf("upload to %s: expected PermissionDenied, got %v", name, err)
+			}
+		}
+		if _, err := pbClient.CommitUpload(ctx, &pb.CommitUploadRequest{Filename: name, Size: 8}); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("commit %s: expected PermissionDenied, got %v", name, err)
+		}
+		if _, err := pbClient.Stat(ctx, &pb.StatRequest{Filename: name, Partial: true}); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("stat the partial file of %s: expected PermissionDenied, got %v", name, err)
+		}
+		if res, err := pbClient.Stat(ctx, &pb.StatRequest{Filename: name}); err != nil || !res.IsDir {
+			t.Errorf("stat %s: expected a directory, got %v %v", name, res, err)
+		}
+	}
+	if matches, _ := filepath.Glob(filepath.Join(parent, "."+base+".grpcp-*")); len(matches) != 0 {
+		t.Errorf("files are created outside of the root: %v", matches)
+	}
+
+	for _, name := range []string{"../etc/passwd", "/../etc/passwd", "outside/local.txt", "outside/new.txt"} {
+		t.Run(name, func(t *testing.T) {
+			err := client.Copy(ctx, testHost+":"+name, filepath.Join(dir, "denied.txt"))
+			if status.Code(err) != codes.PermissionDenied {
+				t.Errorf("download %s: expected PermissionDenied, got %v", name, err)
+			}
+			err = client.Copy(ctx, testLocal, testHost+":"+name)
+			if status.Code(err) != codes.PermissionDenied {
+				t.Errorf("upload %s: expected PermissionDenied, got %v", name, err)
+			}
+		})
+	}
+}
+
+func TestResume(t *testing.T) {
+	for _, tls := range []bool{true, false} {
+		t.Run("TLS="+strconv.FormatBool(tls), func(t *testing.T) {
+			dir := t.TempDir()
+			content := generateRandomBytes(t)
+			half := len(content) / 2
+			testLocal := filepath.Join(dir, "local.txt")
+			testRemote := filepath.Join(dir, "remote.txt")
+			opt := &grpcp.ClientOption{
+				Host:       testHost,
+				Port:       testPort(tls),
+				Quiet:      true,
+				TLS:        tls,
+				SkipVerify: true,
+				Resume:     true,
+			}
+			client := grpcp.NewClient(opt)
+			ctx := context.Background()
+
+			// upload
+			if err := os.WriteFile(testLocal, content, 0644); err != nil {
+				t.Fatalf("failed to create test file: %s", err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, ".remote.txt.grpcp-part"), content[:half], 0644); err != nil {
+				t.Fatalf("failed to create partial file: %s", err)
+			}
+			if err := client.Copy(ctx, testLocal, testHost+":"+testRemote); err != nil {
+				t.Fatalf("failed to resume upload: %s", err)
+			}
+			if b, _ := os.ReadFile(testRemote); !bytes.Equal(content, b) {
+				t.Fatalf("content mismatch: expected %d bytes, got %d bytes", len(content), len(b))
+			}
+
+			// download
+			testLocal = filepath.Join(dir, "download.txt")
+			if err := os.WriteFile(filepath.Join(dir, ".download.txt.grpcp-part"), content[:half], 0644); err != nil {
+				t.Fatalf("failed to create partial file: %s", err)
+			}
+			if err := client.Copy(ctx, testHost+":"+testRemote, testLocal); err != nil {
+				t.Fatalf("failed to resume download: %s", err)
+			}
+			if b, _ := os.ReadFile(testLocal); !bytes.Equal(content, b) {
+				t.Fatalf("content mismatch: expected %d bytes, got %d bytes", len(content), len(b))
+			}
+
+			// partial files are renamed
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 3 {
+				t.Errorf("unexpected files remain: %v", entries)
+			}
+
+			// already completed
+			if err := client.Copy(ctx, testHost+":"+testRemote, testLocal); err != nil {
+				t.Fatalf("failed to resume completed download: %s", err)
+			}
+			if b, _ := os.ReadFile(testLocal); !bytes.Equal(content, b) {
+				t.Fatalf("content mismatch: expected %d bytes, got %d bytes", len(content), len(b))
+			}
+
+			// the partial file is not created for a missing remote file
+			missing := filepath.Join(dir, "missing.txt")
+			if err := client.Copy(ctx, testHost+":"+filepath.Join(dir, "no-such-file.txt"), missing); status.Code(err) != codes.NotFound {
+				t.Errorf("resume missing file: expected NotFound, got %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, ".missing.txt.grpcp-part")); !os.IsNotExist(err) {
+				t.Errorf("partial file should not be created: %v", err)
+			}
+		})
+	}
+}
+
+func TestAtomicDownload(t *testing.T) {
+	dir := t.TempDir()
+	content := generateRandomBytes(t)
+	testRemote := filepath.Join(dir, "remote.txt")
+	if err := os.WriteFile(testRemote, content, 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	testLocal := filepath.Join(dir, "local.txt")
+	if err := os.WriteFile(testLocal, []byte("old content"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	// the download is cancelled in the middle by the rate limit
+	client := grpcp.NewClient(&grpcp.ClientOption{
+		Port:      testPort(false),
+		Quiet:     true,
+		LimitRate: int64(len(content)) / 3,
+	})
+	for _, name := range []string{testLocal, filepath.Join(dir, "new.txt")} {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		err := client.Copy(ctx, testHost+":"+testRemote, name)
+		cancel()
+		if err == nil {
+			t.Fatalf("download to %s should fail", name)
+		}
+	}
+	if b, _ := os.ReadFile(testLocal); string(b) != "old content" {
+		t.Errorf("existing destination should be untouched: %q", b)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("unexpected files remain: %v", entries)
+	}
+
+	client.Option.LimitRate = 0
+	if err := client.Copy(context.Background(), testHost+":"+testRemote, testLocal); err != nil {
+		t.Fatalf("failed to download: %s", err)
+	}
+	if b, _ := os.ReadFile(testLocal); !bytes.Equal(content, b) {
+		t.Errorf("content mismatch: expected %d bytes, got %d bytes", len(content), len(b))
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	for _, algo := range []string{grpcp.ChecksumSHA256, grpcp.ChecksumXXHash, grpcp.ChecksumBLAKE3, grpcp.ChecksumNone} {
+		t.Run(algo, func(t *testing.T) {
+			dir := t.TempDir()
+			content := generateRandomBytes(t)
+			testLocal := filepath.Join(dir, "local.txt")
+			testRemote := filepath.Join(dir, "remote.txt")
+			testDownload := filepath.Join(dir, "download.txt")
+			if err := os.WriteFile(testLocal, content, 0644); err != nil {
+				t.Fatalf("failed to create test file: %s", err)
+			}
+			client := grpcp.NewClient(&grpcp.ClientOption{
+				Port:     testPort(false),
+				Quiet:    true,
+				Checksum: algo,
+			})
+			ctx := context.Background()
+			if err := client.Copy(ctx, testLocal, testHost+":"+testRemote); err != nil {
+				t.Fatalf("failed to upload: %s", err)
+			}
+			if err := client.Copy(ctx, testHost+":"+testRemote, testDownload); err != nil {
+				t.Fatalf("failed to download: %s", err)
+			}
+			if b, _ := os.ReadFile(testDownload); !bytes.Equal(content, b) {
+				t.Fatalf("content mismatch: expected %d bytes, got %d bytes", len(content), len(b))
+			}
+		})
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	conn, err := grpc.NewClient(
+		fmt.Sprintf("%s:%d", testHost, testPort(false)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer conn.Close()
+	stream, err := pb.NewFileTransferServiceClient(conn).Upload(context.Background())
+	if err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	dir := t.TempDir()
+	content := []byte("hello world")
+	for _, req := range []*pb.FileUploadRequest{
+		{Filename: filepath.Join(dir, "remote.txt"), Content: content, Size: int64(len(content)), ChecksumAlgorithm: grpcp.ChecksumSHA256},
+		{Checksum: []byte("invalid checksum")},
+	} {
+		if err := stream.Send(req); err != nil {
+			t.Fatalf("failed to send: %s", err)
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	if status.Code(err) != codes.DataLoss {
+		t.Errorf("expected DataLoss, got %v", err)
+	}
+	// the temporary file is removed
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("unexpected files remain: %v", entries)
+	}
+}
+
+func TestRecursive(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	files := map[string][]byte{
+		"a.txt":         generateRandomBytes(t),
+		"sub/b.txt":     generateRandomBytes(t),
+		"sub/sub/c.txt": {},
+	}
+	for name, content := range files {
+		p := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("failed to create directory: %s", err)
+		}
+		if err := os.WriteFile(p, content, 0644); err != nil {
+			t.Fatalf("failed to create test file: %s", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(src, "empty"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+
+	client := grpcp.NewClient(&grpcp.ClientOption{
+		Port:      testPort(false),
+		Quiet:     true,
+		Recursive: true,
+	})
+	ctx := context.Background()
+	remote := filepath.Join(dir, "remote")
+	if err := client.Copy(ctx, src, testHost+":"+remote); err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	local := filepath.Join(dir, "local")
+	if err := os.Mkdir(local, 0755); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	// download into the existing directory
+	if err := client.Copy(ctx, testHost+":"+remote, local); err != nil {
+		t.Fatalf("failed to download: %s", err)
+	}
+
+	for _, d := range []string{remote, filepath.Join(local, "remote")} {
+		for name, content := range files {
+			b, err := os.ReadFile(filepath.Join(d, name))
+			if err != nil {
+				t.Fatalf("failed to read file: %s", err)
+			}
+			if !bytes.Equal(content, b) {
+				t.Errorf("content mismatch %s: expected %d bytes, got %d bytes", name, len(content), len(b))
+			}
+		}
+		if st, err := os.Stat(filepath.Join(d, "empty")); err != nil || !st.IsDir() {
+			t.Errorf("empty directory is not copied: %v", err)
+		}
+	}
+}
+
+func TestPreserve(t *testing.T) {
+	dir := t.TempDir()
+	testLocal := filepath.Join(dir, "local.sh")
+	testRemote := filepath.Join(dir, "remote.sh")
+	testDownload := filepath.Join(dir, "download.sh")
+	if err := os.WriteFile(testLocal, generateRandomBytes(t), 0755); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(testLocal, mtime, mtime); err != nil {
+		t.Fatalf("failed to change times: %s", err)
+	}
+
+	client := grpcp.NewClient(&grpcp.ClientOption{
+		Port:     testPort(false),
+		Quiet:    true,
+		Preserve: true,
+	})
+	ctx := context.Background()
+	if err := client.Copy(ctx, testLocal, testHost+":"+testRemote); err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	if err := client.Copy(ctx, testHost+":"+testRemote, testDownload); err != nil {
+		t.Fatalf("failed to download: %s", err)
+	}
+	for _, name := range []string{testRemote, testDownload} {
+		st, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("failed to stat: %s", err)
+		}
+		if st.Mode().Perm() != 0755 {
+			t.Errorf("%s: mode mismatch: expected 0755, got %o", name, st.Mode().Perm())
+		}
+		if !st.ModTime().Equal(mtime) {
+			t.Errorf("%s: mtime mismatch: expected %s, got %s", name, mtime, st.ModTime())
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	dir := t.TempDir()
+	testLocal := filepath.Join(dir, "local.txt")
+	testRemote := filepath.Join(dir, "remote.txt")
+	if err := os.WriteFile(testLocal, generateRandomBytes(t), 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	ctx := context.Background()
+	for _, token := range []string{"", "invalid-token"} {
+		client := grpcp.NewClient(&grpcp.ClientOption{
+			Port:  testAuthPort,
+			Quiet: true,
+			Token: token,
+		})
+		if err := client.Copy(ctx, testLocal, testHost+":"+testRemote); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("token %q: expected Unauthenticated, got %v", token, err)
+		}
+		if _, err := client.Ping(ctx); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("token %q: expected Unauthenticated, got %v", token, err)
+		}
+	}
+
+	client := grpcp.NewClient(&grpcp.ClientOption{
+		Port:  testAuthPort,
+		Quiet: true,
+		Token: testToken,
+	})
+	if err := client.Copy(ctx, testLocal, testHost+":"+testRemote); err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	if err := client.Copy(ctx, testHost+":"+testRemote, filepath.Join(dir, "download.txt")); err != nil {
+		t.Fatalf("failed to download: %s", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	ctx := context.Background()
+	// remote shutdown is disabled by default
+	client := grpcp.NewClient(&grpcp.ClientOption{Port: testPort(false), Host: testHost})
+	if err := client.Shutdown(ctx); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+
+	port := testPortFrom + 11
+	tokenFile := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(tokenFile, []byte("admin:admin-token\nuser:user-token\n"), 0600); err != nil {
+		t.Fatalf("failed to write token file: %s", err)
+	}
+	opt := &grpcp.ServerOption{
+		Port:                port,
+		Listen:              testHost,
+		TokenFile:           tokenFile,
+		AllowRemoteShutdown: true,
+		AdminNames:          []string{"admin"},
+	}
+	// admin names must be qualified not to confuse token names with common names
+	if err := grpcp.RunServer(ctx, opt); err == nil || !strings.Contains(err.Error(), "admin name must be") {
+		t.Errorf("unqualified admin name: expected an error, got %v", err)
+	}
+	opt.AdminNames = []string{"cn:admin", "token:admin"}
+	runServerWithClientOption(opt, &grpcp.ClientOption{Port: port, Host: testHost, Token: "user-token"})
+
+	client = grpcp.NewClient(&grpcp.ClientOption{Port: port, Host: testHost, Token: "user-token"})
+	if err := client.Shutdown(ctx); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("non-admin: expected PermissionDenied, got %v", err)
+	}
+	admin := grpcp.NewClient(&grpcp.ClientOption{Port: port, Host: testHost, Token: "admin-token"})
+	if err := admin.Shutdown(ctx); err != nil {
+		t.Fatalf("failed to shutdown: %s", err)
+	}
+	for i := 0; ; i++ {
+		if _, err := admin.Ping(ctx); status.Code(err) == codes.Unavailable {
+			break
+		}
+		if i >= 10 {
+			t.Fatal("server is still running after shutdown")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestServerContext(t *testing.T) {
+	port := testPortFrom + 12
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opt := &grpcp.ServerOption{
+		Port:            port,
+		Listen:          testHost,
+		ShutdownTimeout: 500 * time.Millisecond,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- grpcp.RunServer(ctx, opt)
+	}()
+	client := grpcp.NewClient(&grpcp.ClientOption{Port: port, Host: testHost})
+	for i := 0; ; i++ {
+		if _, err := client.Ping(ctx); err == nil {
+			break
+		} else if i >= 10 {
+			t.Fatalf("failed to run server: %s", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// an upload that never completes is cancelled after the shutdown timeout
+	conn, err := grpc.NewClient(
+		fmt.Sprintf("%s:%d", testHost, port),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer conn.Close()
+	stream, err := pb.NewFileTransferServiceClient(conn).Upload(context.Background())
+	if err != nil {
+		t.Fatalf("failed to start upload: %s", err)
+	}
+	filename := filepath.Join(t.TempDir(), "remote.txt")
+	if err := stream.Send(&pb.FileUploadRequest{Filename: filename, Size: 10, Content: []byte("abc")}); err != nil {
+		t.Fatalf("failed to send: %s", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("server returned an error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop after the context was cancelled")
+	}
+	if elapsed := time.Since(start); elapsed < opt.ShutdownTimeout {
+		t.Errorf("server stopped before the shutdown timeout: %s", elapsed)
+	}
+	if _, err := stream.CloseAndRecv(); err == nil {
+		t.Error("expected the upload to fail")
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("incomplete file should not exist: %v", err)
+	}
+
+	// the port is released
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		errCh <- grpcp.RunServer(ctx, opt)
+	}()
+	for i := 0; ; i++ {
+		if _, err := client.Ping(ctx); err == nil {
+			break
+		} else if i >= 10 {
+			t.Fatalf("failed to restart server: %s", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Errorf("server returned an error: %s", err)
+	}
+}
+
+func TestStatusCodes(t *testing.T) {
+	dir := t.TempDir()
+	testLocal := filepath.Join(dir, "local.txt")
+	if err := os.WriteFile(testLocal, []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	client := grpcp.NewClient(&grpcp.ClientOption{
+		Host:  testHost,
+		Port:  testPort(false),
+		Quiet: true,
+	})
+	tests := []struct {
+		name     string
+		src      string
+		dest     string
+		code     codes.Code
+		reason   string
+		exitCode int
+	}{
+		{"download not found", testHost + ":" + filepath.Join(dir, "missing.txt"), filepath.Join(dir, "a.txt"), codes.NotFound, grpcp.ReasonFileNotFound, grpcp.ExitNotFound},
+		{"upload not found", testLocal, testHost + ":" + filepath.Join(dir, "missing", "a.txt"), codes.NotFound, grpcp.ReasonFileNotFound, grpcp.ExitNotFound},
+		{"download directory", testHost + ":" + dir, filepath.Join(dir, "b.txt"), codes.FailedPrecondition, grpcp.ReasonIsDirectory, grpcp.ExitInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := client.Copy(context.Background(), tt.src, tt.dest)
+			st, ok := status.FromError(err)
+			if !ok || st.Code() != tt.code {
+				t.Fatalf("expected %s, got %v", tt.code, err)
+			}
+			var reason string
+			for _, d := range st.Details() {
+				if info, ok := d.(*errdetails.ErrorInfo); ok && info.Domain == grpcp.ErrorDomain {
+					reason = info.Reason
+				}
+			}
+			if reason != tt.reason {
+				t.Errorf("expected reason %s, got %q", tt.reason, reason)
+			}
+			if code := grpcp.ExitCode(err); code != tt.exitCode {
+				t.Errorf("expected exit code %d, got %d", tt.exitCode, code)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	port := testPortFrom + 13
+	dir := t.TempDir()
+	testLocal := filepath.Join(dir, "local.txt")
+	content := generateRandomBytes(t)
+	if err := os.WriteFile(testLocal, content, 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	testRemote := filepath.Join(dir, "remote.txt")
+	opt := &grpcp.ClientOption{
+		Host:  testHost,
+		Port:  port,
+		Quiet: true,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the server is not running yet
+	client := grpcp.NewClient(opt)
+	if err := client.Copy(ctx, testLocal, testHost+":"+testRemote); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable, got %v", err)
+	}
+
+	opt.Retry = grpcp.RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     500 * time.Millisecond,
+		Jitter:         0.2,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- client.Copy(ctx, testLocal, testHost+":"+testRemote)
+	}()
+	time.Sleep(300 * time.Millisecond)
+	go grpcp.RunServer(ctx, &grpcp.ServerOption{Port: port, Listen: testHost})
+	if err := <-errCh; err != nil {
+		t.Fatalf("failed to copy with retries: %s", err)
+	}
+	b, err := os.ReadFile(testRemote)
+	if err != nil {
+		t.Fatalf("failed to read remote file: %s", err)
+	}
+	if !bytes.Equal(content, b) {
+		t.Error("content mismatch")
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".remote.txt.grpcp-part")); !os.IsNotExist(err) {
+		t.Errorf("partial file should be removed: %v", err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	contents := map[string][]byte{
+		"random": generateRandomBytes(t),
+		"text":   bytes.Repeat([]byte("2024-01-01T00:00:00Z INFO grpcp test log line\n"), 1000),
+	}
+	for _, codec := range []string{grpcp.CompressionGzip, grpcp.CompressionZstd, grpcp.CompressionSnappy, grpcp.CompressionAuto} {
+		for name, content := range contents {
+			t.Run(codec+"/"+name, func(t *testing.T) {
+				dir := t.TempDir()
+				testLocal := filepath.Join(dir, "local.txt")
+				if err := os.WriteFile(testLocal, content, 0644); err != nil {
+					t.Fatalf("failed to create test file: %s", err)
+				}
+				testRemote := filepath.Join(dir, "remote.txt")
+				testDownload := filepath.Join(dir, "download.txt")
+				client := grpcp.NewClient(&grpcp.ClientOption{
+					Host:     testHost,
+					Port:     testPort(false),
+					Quiet:    true,
+					Compress: codec,
+				})
+				ctx := context.Background()
+				if err := client.Copy(ctx, testLocal, testHost+":"+testRemote); err != nil {
+					t.Fatalf("failed to upload: %s", err)
+				}
+				if err := client.Copy(ctx, testHost+":"+testRemote, testDownload); err != nil {
+					t.Fatalf("failed to download: %s", err)
+				}
+				for _, name := range []string{testRemote, testDownload} {
+					b, err := os.ReadFile(name)
+					if err != nil {
+						t.Fatalf("failed to read file: %s", err)
+					}
+					if !bytes.Equal(content, b) {
+						t.Errorf("%s: content mismatch", name)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestLimitRate(t *testing.T) {
+	for s, expected := range map[string]grpcp.ByteSize{"20M": 20 << 20, "512k": 512 << 10, "1.5G": 3 << 29, "1000": 1000} {
+		var b grpcp.ByteSize
+		if err := b.UnmarshalText([]byte(s)); err != nil {
+			t.Errorf("failed to parse %s: %s", s, err)
+		} else if b != expected {
+			t.Errorf("%s: expected %d, got %d", s, expected, b)
+		}
+	}
+
+	dir := t.TempDir()
+	testLocal := filepath.Join(dir, "local.txt")
+	content := generateRandomBytes(t)
+	if err := os.WriteFile(testLocal, content, 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	testRemote := filepath.Join(dir, "remote.txt")
+	ctx := context.Background()
+
+	// the first second is allowed by the burst, and the rest takes more than a second
+	limit := int64(len(content)) / 3
+	client := grpcp.NewClient(&grpcp.ClientOption{
+		Host:      testHost,
+		Port:      testPort(false),
+		Quiet:     true,
+		LimitRate: limit,
+	})
+	start := time.Now()
+	if err := client.Copy(ctx, testLocal, testHost+":"+testRemote); err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("client: upload was not limited: %s", elapsed)
+	}
+
+	port := testPortFrom + 14
+	runServerWithOption(&grpcp.ServerOption{
+		Port:          port,
+		Listen:        testHost,
+		ConnRateLimit: limit,
+	})
+	client = grpcp.NewClient(&grpcp.ClientOption{
+		Host:  testHost,
+		Port:  port,
+		Quiet: true,
+	})
+	start = time.Now()
+	if err := client.Copy(ctx, testHost+":"+testRemote, filepath.Join(dir, "download.txt")); err != nil {
+		t.Fatalf("failed to download: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("server: download was not limited: %s", elapsed)
+	}
+}
+
+func TestParallel(t *testing.T) {
+	dir := t.TempDir()
+	testLocal := filepath.Join(dir, "local.txt")
+	content := make([]byte, grpcp.StreamBufferSize*5+123)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatalf("failed to generate random bytes: %s", err)
+	}
+	if err := os.WriteFile(testLocal, content, 0600); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	testRemote := filepath.Join(dir, "remote.txt")
+	testDownload := filepath.Join(dir, "download.txt")
+	// a larger file left by a previous transfer is truncated
+	if err := os.WriteFile(filepath.Join(dir, ".remote.txt.grpcp-ranges"), make([]byte, len(content)*2), 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+
+	client := grpcp.NewClient(&grpcp.ClientOption{
+		Host:        testHost,
+		Port:        testPort(false),
+		Quiet:       true,
+		Parallel:    4,
+		Connections: 2,
+		Preserve:    true,
+	})
+	ctx := context.Background()
+	if err := client.Copy(ctx, testLocal, testHost+":"+testRemote); err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	if err := client.Copy(ctx, testHost+":"+testRemote, testDownload); err != nil {
+		t.Fatalf("failed to download: %s", err)
+	}
+	for _, name := range []string{testRemote, testDownload} {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("failed to read file: %s", err)
+		}
+		if !bytes.Equal(content, b) {
+			t.Errorf("%s: content mismatch", name)
+		}
+		st, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("failed to stat: %s", err)
+		}
+		if st.Mode().Perm() != 0600 {
+			t.Errorf("%s: mode mismatch: expected 0600, got %o", name, st.Mode().Perm())
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read dir: %s", err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("temporary files should be removed: %v", entries)
+	}
+
+	// the ranges are not committed if the checksum of the whole file does not match
+	conn, err := grpc.NewClient(
+		fmt.Sprintf("%s:%d", testHost, testPort(false)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer conn.Close()
+	pbClient := pb.NewFileTransferServiceClient(conn)
+	rangesFile := filepath.Join(dir, ".corrupted.txt.grpcp-ranges")
+	if err := os.WriteFile(rangesFile, []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	_, err = pbClient.CommitUpload(ctx, &pb.CommitUploadRequest{
+		Filename:          filepath.Join(dir, "corrupted.txt"),
+		Size:              5,
+		ChecksumAlgorithm: grpcp.ChecksumSHA256,
+		Checksum:          []byte("invalid checksum"),
+	})
+	if status.Code(err) != codes.DataLoss {
+		t.Errorf("commit with checksum mismatch: expected DataLoss, got %v", err)
+	}
+	for _, name := range []string{"corrupted.txt", ".corrupted.txt.grpcp-ranges"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should not exist: %v", name, err)
+		}
+	}
+
+	// stale ranges are removed by an upload without ranges
+	if err := os.WriteFile(rangesFile, []byte("stale"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	if err := grpcp.NewClient(&grpcp.ClientOption{Port: testPort(false), Quiet: true}).Copy(ctx, testLocal, testHost+":"+filepath.Join(dir, "corrupted.txt")); err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	if _, err := os.Stat(rangesFile); !os.IsNotExist(err) {
+		t.Errorf("stale ranges should be removed: %v", err)
+	}
+
+	// the end of a range overflowing int64 is out of range
+	stream, err := pbClient.Upload(ctx)
+	if err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	stream.Send(&pb.FileUploadRequest{Filename: filepath.Join(dir, "overflow.txt"), Content: []byte("hello"), Size: 10, Offset: 1, Length: math.MaxInt64})
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.OutOfRange {
+		t.Errorf("upload overflowing range: expected OutOfRange, got %v", err)
+	}
+	dstream, err := pbClient.Download(ctx, &pb.FileDownloadRequest{Filename: testRemote, Offset: 1, Length: math.MaxInt64})
+	if err != nil {
+		t.Fatalf("failed to download: %s", err)
+	}
+	if _, err := dstream.Recv(); status.Code(err) != codes.OutOfRange {
+		t.Errorf("download overflowing range: expected OutOfRange, got %v", err)
+	}
+
+	// the remote file modified between the ranges is not committed
+	slow := grpcp.NewClient(&grpcp.ClientOption{
+		Host:      testHost,
+		Port:      testPort(false),
+		Quiet:     true,
+		Parallel:  4,
+		LimitRate: int64(len(content)) / 3,
+	})
+	modified := filepath.Join(dir, "modified.txt")
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		mtime := time.Now().Add(time.Hour)
+		os.Chtimes(testRemote, mtime, mtime)
+	}()
+	if err := slow.Copy(ctx, testHost+":"+testRemote, modified); status.Code(err) != codes.DataLoss {
+		t.Errorf("download modified file: expected DataLoss, got %v", err)
+	}
+	if _, err := os.Stat(modified); !os.IsNotExist(err) {
+		t.Errorf("download of the modified file should not be committed: %v", err)
+	}
+}
+
+func TestFileOperations(t *testing.T) {
+	ctx := context.Background()
+	client := grpcp.NewClient(&grpcp.ClientOption{
+		Port:  testRootPort,
+		Quiet: true,
+	})
+	remote := func(p string) string {
+		return testHost + ":/fileops/" + p
+	}
+	if err := client.Mkdir(ctx, remote("a/b"), false); status.Code(err) != codes.NotFound {
+		t.Errorf("mkdir without parents: expected NotFound, got %v", err)
+	}
+	if err := client.Mkdir(ctx, remote("a/b"), true); err != nil {
+		t.Fatalf("failed to mkdir: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(testRoot, "fileops", "a", "file.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+
+	entries, err := client.List(ctx, remote("a"))
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if e := entries[0]; e.Name != "b" || !e.IsDir() {
+		t.Errorf("unexpected entry: %#v", e)
+	}
+	if e := entries[1]; e.Name != "file.txt" || e.IsDir() || e.Size != 5 || e.Mode.Perm() != 0644 {
+		t.Errorf("unexpected entry: %#v", e)
+	}
+
+	// move into the directory
+	if err := client.Rename(ctx, remote("a/file.txt"), "/fileops/a/b"); err != nil {
+		t.Fatalf("failed to rename: %s", err)
+	}
+	entries, err = client.List(ctx, remote("a/b/file.txt"))
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "file.txt" {
+		t.Errorf("unexpected entries: %v", entries)
+	}
+	if err := client.Rename(ctx, remote("a/b/file.txt"), "other-host:/fileops/file.txt"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("rename to another host: expected InvalidArgument, got %v", err)
+	}
+
+	if err := client.Remove(ctx, remote("a"), false); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("remove non-empty directory: expected FailedPrecondition, got %v", err)
+	}
+	if err := client.Mkdir(ctx, remote("empty"), false); err != nil {
+		t.Fatalf("failed to mkdir: %s", err)
+	}
+	if err := client.Remove(ctx, remote("empty"), false); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("remove empty directory without recursive: expected FailedPrecondition, got %v", err)
+	}
+	if err := client.Remove(ctx, remote("empty"), true); err != nil {
+		t.Fatalf("failed to remove: %s", err)
+	}
+	if err := client.Remove(ctx, remote("a"), true); err != nil {
+		t.Fatalf("failed to remove: %s", err)
+	}
+	if _, err := client.List(ctx, remote("a")); status.Code(err) != codes.NotFound {
+		t.Errorf("list removed directory: expected NotFound, got %v", err)
+	}
+	if err := client.Remove(ctx, remote("a"), true); status.Code(err) != codes.NotFound {
+		t.Errorf("remove missing directory: expected NotFound, got %v", err)
+	}
+	for _, p := range []string{"/", "/fileops/.."} {
+		if err := client.Remove(ctx, testHost+":"+p, true); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("remove root %s: expected PermissionDenied, got %v", p, err)
+		}
+	}
+	if err := client.Remove(ctx, testHost+":/../etc", true); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("remove outside of root: expected PermissionDenied, got %v", err)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	ctx := context.Background()
+	client := grpcp.NewClient(&grpcp.ClientOption{
+		Port:  testRootPort,
+		Quiet: true,
+	})
+	remoteDir := filepath.Join(testRoot, "glob")
+	for _, name := range []string{"a.log", "b.log", "c.txt", "old/d.log"} {
+		p := filepath.Join(remoteDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("failed to create directory: %s", err)
+		}
+		if err := os.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatalf("failed to create test file: %s", err)
+		}
+	}
+	defer os.RemoveAll(remoteDir)
+
+	localDir := t.TempDir()
+	if err := client.Copy(ctx, testHost+":/glob/*.log", localDir); err != nil {
+		t.Fatalf("failed to copy: %s", err)
+	}
+	entries, err := os.ReadDir(localDir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %s", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, ",") != "a.log,b.log" {
+		t.Errorf("unexpected files: %v", names)
+	}
+	if b, _ := os.ReadFile(filepath.Join(localDir, "b.log")); string(b) != "b.log" {
+		t.Errorf("unexpected content: %s", b)
+	}
+
+	// a single match is copied to the file
+	localFile := filepath.Join(localDir, "renamed.txt")
+	if err := client.Copy(ctx, testHost+":/glob/*.txt", localFile); err != nil {
+		t.Fatalf("failed to copy: %s", err)
+	}
+	if b, _ := os.ReadFile(localFile); string(b) != "c.txt" {
+		t.Errorf("unexpected content: %s", b)
+	}
+
+	if err := client.Copy(ctx, testHost+":/glob/*.log", localFile); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("copy multiple files to a file: expected InvalidArgument, got %v", err)
+	}
+	if err := client.Copy(ctx, testHost+":/glob/*.gz", localDir); status.Code(err) != codes.NotFound {
+		t.Errorf("no matches: expected NotFound, got %v", err)
+	}
+	if err := client.Copy(ctx, testHost+":/../*", localDir); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("outside of root: expected PermissionDenied, got %v", err)
+	}
+	if err := client.Copy(ctx, testHost+":/glob/[", localDir); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("invalid pattern: expected InvalidArgument, got %v", err)
+	}
+
+	// an existing file is copied literally
+	for _, name := range []string{"report[1].csv", "report1.csv"} {
+		if err := os.WriteFile(filepath.Join(remoteDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("failed to create test file: %s", err)
+		}
+	}
+	if err := client.Copy(ctx, testHost+":/glob/report[1].csv", localFile); err != nil {
+		t.Fatalf("failed to copy: %s", err)
+	}
+	if b, _ := os.ReadFile(localFile); string(b) != "report[1].csv" {
+		t.Errorf("unexpected content: %s", b)
+	}
+}
+
+func TestGlobRootWithSpecialCharacters(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root[1]")
+	// matched by the root directory as a pattern
+	for _, d := range []string{root, filepath.Join(dir, "root1")} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatalf("failed to create directory: %s", err)
+		}
+		if err := os.WriteFile(filepath.Join(d, "a.log"), []byte(filepath.Base(d)), 0644); err != nil {
+			t.Fatalf("failed to create test file: %s", err)
+		}
+	}
+	port := testPortFrom + 15
+	runServerWithOption(&grpcp.ServerOption{
+		Port:   port,
+		Listen: testHost,
+		Root:   root,
+	})
+	client := grpcp.NewClient(&grpcp.ClientOption{
+		Port:  port,
+		Quiet: true,
+	})
+	localFile := filepath.Join(t.TempDir(), "a.log")
+	if err := client.Copy(context.Background(), testHost+":/*.log", localFile); err != nil {
+		t.Fatalf("failed to copy: %s", err)
+	}
+	if b, _ := os.ReadFile(localFile); string(b) != "root[1]" {
+		t.Errorf("unexpected content: %s", b)
+	}
+}
+
+func TestMultipleSources(t *testing.T) {
+	ctx := context.Background()
+	client := grpcp.NewClient(&grpcp.ClientOption{
+		Port:  testRootPort,
+		Quiet: true,
+	})
+	localDir := t.TempDir()
+	var srcs []string
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		p := filepath.Join(localDir, name)
+		if err := os.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatalf("failed to create test file: %s", err)
+		}
+		srcs = append(srcs, p)
+	}
+	remoteDir := filepath.Join(testRoot, "multi")
+	if err := os.Mkdir(remoteDir, 0755); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	defer os.RemoveAll(remoteDir)
+
+	if err := client.CopyFiles(ctx, srcs, testHost+":/multi"); err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if b, _ := os.ReadFile(filepath.Join(remoteDir, name)); string(b) != name {
+			t.Errorf("unexpected content of %s: %s", name, b)
+		}
+	}
+
+	// the other sources are copied even if one of them fails
+	downloadDir := t.TempDir()
+	remoteSrcs := []string{
+		testHost + ":/multi/a.txt",
+		testHost + ":/multi/missing.txt",
+		testHost + ":/multi/c.txt",
+	}
+	if err := client.CopyFiles(ctx, remoteSrcs, downloadDir); status.Code(err) != codes.NotFound {
+		t.Errorf("missing source: expected NotFound, got %v", err)
+	}
+	for _, name := range []string{"a.txt", "c.txt"} {
+		if b, _ := os.ReadFile(filepath.Join(downloadDir, name)); string(b) != name {
+			t.Errorf("unexpected content of %s: %s", name, b)
+		}
+	}
+
+	if err := client.CopyFiles(ctx, srcs, testHost+":/multi/a.txt"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("copy to a file: expected InvalidArgument, got %v", err)
+	}
+	if err := client.CopyFiles(ctx, []string{testHost + ":/multi/a.txt", "localhost:/multi/b.txt"}, downloadDir); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("sources on different hosts: expected InvalidArgument, got %v", err)
+	}
+
+	// sources with the same name are rejected before any transfer
+	if err := os.Mkdir(filepath.Join(localDir, "sub"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(localDir, "sub", "b.txt"), []byte("sub/b.txt"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	sameDir := filepath.Join(remoteDir, "same")
+	if err := os.Mkdir(sameDir, 0755); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	dupSrcs := []string{srcs[0], srcs[1], filepath.Join(localDir, "sub", "b.txt")}
+	if err := client.CopyFiles(ctx, dupSrcs, testHost+":/multi/same"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("upload sources with the same name: expected InvalidArgument, got %v", err)
+	}
+	if entries, _ := os.ReadDir(sameDir); len(entries) != 0 {
+		t.Errorf("no files should be uploaded: %v", entries)
+	}
+	dupDir := t.TempDir()
+	if err := client.CopyFiles(ctx, []string{testHost + ":/multi/a.txt", testHost + ":/multi/b.txt", testHost + ":/multi/same/../a.txt"}, dupDir); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("download sources with the same name: expected InvalidArgument, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dupDir); len(entries) != 0 {
+		t.Errorf("no files should be downloaded: %v", entries)
+	}
+}
+
+func TestStdio(t *testing.T) {
+	ctx := context.Background()
+	content := generateRandomBytes(t)
+	var stdout bytes.Buffer
+	client := grpcp.NewClient(&grpcp.ClientOption{
+		Port:     testPort(false),
+		Quiet:    true,
+		Checksum: grpcp.ChecksumSHA256,
+		Compress: grpcp.CompressionAuto,
+		Stdin:    bytes.NewReader(content),
+		Stdout:   &stdout,
+	})
+	dir := t.TempDir()
+	remoteFile := filepath.Join(dir, "stdin.dat")
+	if err := client.Copy(ctx, "-", testHost+":"+remoteFile); err != nil {
+		t.Fatalf("failed to upload stdin: %s", err)
+	}
+	if b, _ := os.ReadFile(remoteFile); !bytes.Equal(b, content) {
+		t.Errorf("unexpected content of the uploaded file: %d bytes", len(b))
+	}
+	if err := client.Copy(ctx, "-", testHost+":"+dir+"/"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("upload stdin to a directory: expected InvalidArgument, got %v", err)
+	}
+
+	if err := client.Copy(ctx, testHost+":"+remoteFile, "-"); err != nil {
+		t.Fatalf("failed to download to stdout: %s", err)
+	}
+	if !bytes.Equal(stdout.Bytes(), content) {
+		t.Errorf("unexpected content of stdout: %d bytes", stdout.Len())
+	}
+
+	// glob matches are concatenated
+	if err := os.WriteFile(filepath.Join(dir, "text.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	stdout.Reset()
+	if err := client.Copy(ctx, testHost+":"+filepath.Join(dir, "*"), "-"); err != nil {
+		t.Fatalf("failed to download to stdout: %s", err)
+	}
+	if !bytes.Equal(stdout.Bytes(), append(content, "hello"...)) {
+		t.Errorf("unexpected content of stdout: %d bytes", stdout.Len())
+	}
+
+	// the size of the content of unknown size is required in the last message
+	conn, err := grpc.NewClient(
+		fmt.Sprintf("%s:%d", testHost, testPort(false)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer conn.Close()
+	stream, err := pb.NewFileTransferServiceClient(conn).Upload(ctx)
+	if err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	truncated := filepath.Join(dir, "truncated.txt")
+	if err := stream.Send(&pb.FileUploadRequest{Filename: truncated, Content: []byte("hello"), Size: -1}); err != nil {
+		t.Fatalf("failed to send: %s", err)
+	}
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.DataLoss {
+		t.Errorf("upload without size: expected DataLoss, got %v", err)
+	}
+	if _, err := os.Stat(truncated); !os.IsNotExist(err) {
+		t.Errorf("truncated file should not be created: %v", err)
+	}
+}
+
+func TestRelay(t *testing.T) {
+	ctx := context.Background()
+	client := grpcp.NewClient(&grpcp.ClientOption{
+		Port:     testRootPort,
+		Quiet:    true,
+		Checksum: grpcp.ChecksumSHA256,
+	})
+	content := generateRandomBytes(t)
+	remoteDir := filepath.Join(testRoot, "relay")
+	if err := os.MkdirAll(filepath.Join(remoteDir, "dest"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	defer os.RemoveAll(remoteDir)
+	if err := os.WriteFile(filepath.Join(remoteDir, "src.dat"), content, 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+
+	// both hosts are the same server in this test
+	if err := client.Copy(ctx, testHost+":/relay/src.dat", "localhost:/relay/dest"); err != nil {
+		t.Fatalf("failed to relay: %s", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(remoteDir, "dest", "src.dat")); !bytes.Equal(b, content) {
+		t.Errorf("unexpected content of the relayed file: %d bytes", len(b))
+	}
+	if err := client.Copy(ctx, testHost+":/relay/src.dat", "localhost:/relay/dest/renamed.dat"); err != nil {
+		t.Fatalf("failed to relay: %s", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(remoteDir, "dest", "renamed.dat")); !bytes.Equal(b, content) {
+		t.Errorf("unexpected content of the relayed file: %d bytes", len(b))
+	}
+
+	if err := client.Copy(ctx, testHost+":/relay/missing.dat", "localhost:/relay/dest/missing.dat"); status.Code(err) != codes.NotFound {
+		t.Errorf("relay missing file: expected NotFound, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, "dest", "missing.dat")); !os.IsNotExist(err) {
+		t.Errorf("destination of the failed relay should not be created: %v", err)
+	}
+	if err := client.Copy(ctx, testHost+":/relay/src.dat", "localhost:/../outside.dat"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("relay to outside of root: expected PermissionDenied, got %v", err)
+	}
+
+	// the port of each host overrides --port, and --preserve applies the metadata of the source
+	srcFile := filepath.Join(t.TempDir(), "preserve.sh")
+	if err := os.WriteFile(srcFile, content, 0755); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(srcFile, mtime, mtime); err != nil {
+		t.Fatalf("failed to change times: %s", err)
+	}
+	pclient := grpcp.NewClient(&grpcp.ClientOption{
+		Port:     testPort(true),
+		Quiet:    true,
+		Preserve: true,
+	})
+	src := fmt.Sprintf("%s:%d:%s", testHost, testPort(false), srcFile)
+	dest := fmt.Sprintf("localhost:%d:/relay/dest/", testRootPort)
+	if err := pclient.Copy(ctx, src, dest); err != nil {
+		t.Fatalf("failed to relay with ports: %s", err)
+	}
+	st, err := os.Stat(filepath.Join(remoteDir, "dest", "preserve.sh"))
+	if err != nil {
+		t.Fatalf("failed to stat: %s", err)
+	}
+	if st.Mode().Perm() != 0755 {
+		t.Errorf("mode mismatch: expected 0755, got %o", st.Mode().Perm())
+	}
+	if !st.ModTime().Equal(mtime) {
+		t.Errorf("mtime mismatch: expected %s, got %s", mtime, st.ModTime())
+	}
+}
+
+func TestFileOperationsSymlink(t *testing.T) {
+	ctx := context.Background()
+	client := grpcp.NewClient(&grpcp.ClientOption{
+		Port:  testRootPort,
+		Quiet: true,
+	})
+	dir := filepath.Join(testRoot, "symlink")
+	target := filepath.Join(dir, "target")
+	if err := os.MkdirAll(target, 0755); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(target, "file.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	for _, name := range []string{"link1", "link2"} {
+		if err := os.Symlink("target", filepath.Join(dir, name)); err != nil {
+			t.Fatalf("failed to create symlink: %s", err)
+		}
+	}
+
+	// the link is removed, not the target
+	if err := client.Remove(ctx, testHost+":/symlink/link1", true); err != nil {
+		t.Fatalf("failed to remove: %s", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "link1")); !os.IsNotExist(err) {
+		t.Errorf("link should be removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "file.txt")); err != nil {
+		t.Errorf("target of the link should not be removed: %v", err)
+	}
+
+	// the link is renamed, not the target
+	if err := client.Rename(ctx, testHost+":/symlink/link2", "/symlink/renamed"); err != nil {
+		t.Fatalf("failed to rename: %s", err)
+	}
+	if st, err := os.Lstat(filepath.Join(dir, "renamed")); err != nil || st.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("renamed file should be the link: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "file.txt")); err != nil {
+		t.Errorf("target of the link should not be moved: %v", err)
+	}
+}
+
+func TestPartialFileSymlink(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	testLocal := filepath.Join(dir, "local.txt")
+	content := generateRandomBytes(t)
+	if err := os.WriteFile(testLocal, content, 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	outside := filepath.Join(dir, "outside.txt")
+	if err := os.WriteFile(outside, []byte("outside"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	for _, name := range []string{".resume.txt.grpcp-part", ".parallel.txt.grpcp-ranges"} {
+		link := filepath.Join(testRoot, name)
+		if err := os.Symlink(outside, link); err != nil {
+			t.Fatalf("failed to create symlink: %s", err)
+		}
+		defer os.Remove(link)
+	}
+
+	for _, tc := range []struct {
+		name string
+		opt  grpcp.ClientOption
+	}{
+		{name: "resume.txt", opt: grpcp.ClientOption{Resume: true}},
+		{name: "parallel.txt", opt: grpcp.ClientOption{Parallel: 2}},
+	} {
+		opt := tc.opt
+		opt.Port, opt.Quiet = testRootPort, true
+		err := grpcp.NewClient(&opt).Copy(ctx, testLocal, testHost+":/"+tc.name)
+		if status.Code(err) != codes.PermissionDenied {
+			t.Errorf("upload %s through the planted symlink: expected PermissionDenied, got %v", tc.name, err)
+		}
+	}
+	if b, _ := os.ReadFile(outside); string(b) != "outside" {
+		t.Errorf("the file outside of the root was modified: %d bytes", len(b))
+	}
+}
+
+func TestRelativeRoot(t *testing.T) {
+	port := testPortFrom + 16
+	runServerWithOption(&grpcp.ServerOption{
+		Port:   port,
+		Listen: testHost,
+		Root:   ".",
+	})
+	client := grpcp.NewClient(&grpcp.ClientOption{
+		Port:  port,
+		Quiet: true,
+	})
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get working directory: %s", err)
+	}
+	// only the root directory is hidden, not the dots in the other parts of the message
+	err = client.Copy(context.Background(), testHost+":/missing.file.txt", filepath.Join(t.TempDir(), "missing.txt"))
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("download missing file: expected NotFound, got %v", err)
+	} else if msg := err.Error(); strings.Contains(msg, cwd) || !strings.Contains(msg, "/missing.file.txt") {
+		t.Errorf("unexpected error message: %s", msg)
+	}
+}
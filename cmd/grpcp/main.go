@@ -10,7 +10,7 @@ import (
 
 func main() {
 	if err := grpcp.RunCLI(context.Background()); err != nil {
-		slog.Error(err.Error())
-		os.Exit(1)
+		slog.Error(grpcp.ErrorMessage(err))
+		os.Exit(grpcp.ExitCode(err))
 	}
 }
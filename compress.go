@@ -0,0 +1,189 @@
+package grpcp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+
+	pb "github.com/fujiwara/grpcp/proto"
+	"github.com/klauspost/compress"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	CompressionNone   = "none"
+	CompressionGzip   = "gzip"
+	CompressionZstd   = "zstd"
+	CompressionSnappy = "snappy"
+	// CompressionAuto chooses the best codec supported by the server, and skips the chunks not compressible.
+	CompressionAuto = "auto"
+)
+
+// supportedCompressions are the codecs supported by this version, in the order of preference for auto.
+var supportedCompressions = []string{CompressionZstd, CompressionSnappy, CompressionGzip}
+
+// maxDecompressedSize is the limit of the decompressed size of a chunk, to protect against compression bombs.
+const maxDecompressedSize = 64 * 1024 * 1024
+
+// minCompressibility is the estimated compressibility of a chunk to be compressed in auto mode.
+// See compress.Estimate.
+const minCompressibility = 0.1
+
+// compressor compresses each chunk of the content independently.
+type compressor struct {
+	codec string
+	auto  bool
+	buf   bytes.Buffer
+	out   []byte
+	gz    *gzip.Writer
+	zstd  *zstd.Encoder
+}
+
+// newCompressor returns the compressor of the codec supported by the peer.
+// It returns nil if the content should not be compressed.
+func newCompressor(codec string, supported []string) (*compressor, error) {
+	c := &compressor{codec: codec}
+	switch codec {
+	case "", CompressionNone:
+		return nil, nil
+	case CompressionAuto:
+		i := slices.IndexFunc(supportedCompressions, func(codec string) bool {
+			return slices.Contains(supported, codec)
+		})
+		if i < 0 {
+			return nil, nil
+		}
+		c.codec = supportedCompressions[i]
+		c.auto = true
+	default:
+		if !slices.Contains(supportedCompressions, codec) || !slices.Contains(supported, codec) {
+			return nil, status.Errorf(codes.Unimplemented, "compression %s is not supported", codec)
+		}
+	}
+	switch c.codec {
+	case CompressionGzip:
+		c.gz = gzip.NewWriter(&c.buf)
+	case CompressionZstd:
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		c.zstd = enc
+	}
+	return c, nil
+}
+
+// compress returns the compressed chunk and its codec.
+// The chunk is returned as is with an empty codec if it is not worth compressing.
+// The returned slice is valid until the next call.
+func (c *compressor) compress(b []byte) ([]byte, string, error) {
+	if c == nil || len(b) == 0 {
+		return b, "", nil
+	}
+	if c.auto && compress.Estimate(b) < minCompressibility {
+		return b, "", nil
+	}
+	var out []byte
+	switch c.codec {
+	case CompressionGzip:
+		c.buf.Reset()
+		c.gz.Reset(&c.buf)
+		if _, err := c.gz.Write(b); err != nil {
+			return nil, "", fmt.Errorf("failed to compress: %w", err)
+		}
+		if err := c.gz.Close(); err != nil {
+			return nil, "", fmt.Errorf("failed to compress: %w", err)
+		}
+		out = c.buf.Bytes()
+	case CompressionZstd:
+		c.out = c.zstd.EncodeAll(b, c.out[:0])
+		out = c.out
+	case CompressionSnappy:
+		c.out = s2.EncodeSnappy(c.out[:cap(c.out)], b)
+		out = c.out
+	}
+	if c.auto && len(out) >= len(b) {
+		return b, "", nil
+	}
+	return out, c.codec, nil
+}
+
+// Close releases the resources of the compressor.
+func (c *compressor) Close() {
+	if c != nil && c.zstd != nil {
+		c.zstd.Close()
+	}
+}
+
+// decompressor decompresses the chunks compressed by compressor.
+type decompressor struct {
+	buf  []byte
+	zstd *zstd.Decoder
+}
+
+// decompress returns the decompressed chunk.
+// The returned slice is valid until the next call.
+func (d *decompressor) decompress(b []byte, codec string) ([]byte, error) {
+	var err error
+	switch codec {
+	case "":
+		return b, nil
+	case CompressionGzip:
+		var r *gzip.Reader
+		r, err = gzip.NewReader(bytes.NewReader(b))
+		if err == nil {
+			var buf bytes.Buffer
+			buf.Grow(len(b))
+			_, err = io.Copy(&buf, io.LimitReader(r, maxDecompressedSize+1))
+			d.buf = buf.Bytes()
+		}
+	case CompressionZstd:
+		if d.zstd == nil {
+			d.zstd, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxDecompressedSize))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create zstd decoder: %w", err)
+			}
+		}
+		d.buf, err = d.zstd.DecodeAll(b, d.buf[:0])
+	case CompressionSnappy:
+		var n int
+		n, err = s2.DecodedLen(b)
+		if err == nil && n > maxDecompressedSize {
+			err = fmt.Errorf("decompressed size %d exceeds the limit", n)
+		}
+		if err == nil {
+			d.buf, err = s2.Decode(d.buf[:cap(d.buf)], b)
+		}
+	default:
+		return nil, status.Errorf(codes.Unimplemented, "compression %s is not supported", codec)
+	}
+	if err == nil && len(d.buf) > maxDecompressedSize {
+		err = fmt.Errorf("decompressed size exceeds the limit %d", maxDecompressedSize)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.DataLoss, "failed to decompress %s content: %s", codec, err)
+	}
+	return d.buf, nil
+}
+
+// Close releases the resources of the decompressor.
+func (d *decompressor) Close() {
+	if d.zstd != nil {
+		d.zstd.Close()
+	}
+}
+
+// serverCompressions returns the compression codecs supported by the server.
+func serverCompressions(ctx context.Context, client pb.FileTransferServiceClient) ([]string, error) {
+	res, err := client.Ping(ctx, &pb.PingRequest{Message: "compressions"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to ping: %w", err)
+	}
+	return res.Compressions, nil
+}
@@ -0,0 +1,257 @@
+package grpcp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"syscall"
+	"unicode"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorDomain is the domain of the error details returned by the server.
+const ErrorDomain = "grpcp"
+
+// Reasons of the error details for file operations.
+const (
+	ReasonFileNotFound  = "FILE_NOT_FOUND"
+	ReasonFileExists    = "FILE_EXISTS"
+	ReasonNoSpace       = "NO_SPACE"
+	ReasonQuotaExceeded = "QUOTA_EXCEEDED"
+	ReasonIsDirectory   = "IS_DIRECTORY"
+	ReasonNotDirectory  = "NOT_DIRECTORY"
+	ReasonNotEmpty      = "DIRECTORY_NOT_EMPTY"
+)
+
+// Exit codes of the CLI.
+const (
+	ExitOK         = 0
+	ExitError      = 1 // other errors
+	ExitInvalid    = 2 // invalid request. fix the arguments
+	ExitNotFound   = 3
+	ExitPermission = 4 // permission denied or authentication failed
+	ExitExists     = 5
+	ExitTemporary  = 6 // temporary failure. retry later
+	ExitDataLoss   = 7 // the transferred content was corrupted. retry the transfer
+)
+
+// errorCode returns the gRPC status code of err.
+// Errors of file operations are mapped to the corresponding codes.
+func errorCode(err error) codes.Code {
+	if err == nil {
+		return codes.OK
+	}
+	if tlsHandshakeFailed(err) {
+		// not temporary. retrying does not fix the certificates
+		return codes.Unauthenticated
+	}
+	if st, ok := status.FromError(err); ok {
+		return st.Code()
+	}
+	switch {
+	case errors.Is(err, syscall.ENOTEMPTY):
+		// ENOTEMPTY is also fs.ErrExist
+		return codes.FailedPrecondition
+	case errors.Is(err, fs.ErrNotExist):
+		return codes.NotFound
+	case errors.Is(err, fs.ErrPermission):
+		return codes.PermissionDenied
+	case errors.Is(err, fs.ErrExist):
+		return codes.AlreadyExists
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		return codes.ResourceExhausted
+	case errors.Is(err, syscall.EISDIR), errors.Is(err, syscall.ENOTDIR):
+		return codes.FailedPrecondition
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	}
+	return codes.Unknown
+}
+
+// tlsHandshakeFailed reports whether err is a failure of the TLS handshake or the certificate verification.
+// These failures reach the client as Unavailable with the message only, so the message is also checked.
+func tlsHandshakeFailed(err error) bool {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		invalid          x509.CertificateInvalidError
+		hostname         x509.HostnameError
+		verification     *tls.CertificateVerificationError
+	)
+	if errors.As(err, &unknownAuthority) || errors.As(err, &invalid) || errors.As(err, &hostname) ||
+		errors.As(err, &verification) || errors.Is(err, errCertificateChanged) {
+		return true
+	}
+	st, ok := rootStatus(err)
+	if !ok || st.Code() != codes.Unavailable {
+		return false
+	}
+	msg := st.Message()
+	return strings.Contains(msg, "authentication handshake failed") ||
+		strings.Contains(msg, "x509: ") ||
+		// the server rejected the client certificate, e.g. "remote error: tls: certificate required"
+		strings.Contains(msg, "remote error: tls: ") && strings.Contains(msg, "certificate")
+}
+
+// errorReason returns the reason of the error details for err.
+func errorReason(err error, code codes.Code) string {
+	switch {
+	case errors.Is(err, syscall.ENOTEMPTY):
+		return ReasonNotEmpty
+	case errors.Is(err, fs.ErrNotExist):
+		return ReasonFileNotFound
+	case errors.Is(err, fs.ErrExist):
+		return ReasonFileExists
+	case errors.Is(err, syscall.ENOSPC):
+		return ReasonNoSpace
+	case errors.Is(err, syscall.EDQUOT):
+		return ReasonQuotaExceeded
+	case errors.Is(err, syscall.EISDIR):
+		return ReasonIsDirectory
+	case errors.Is(err, syscall.ENOTDIR):
+		return ReasonNotDirectory
+	}
+	// NotFound -> NOT_FOUND
+	var b strings.Builder
+	for i, r := range code.String() {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// isRetryable reports whether a request failed with the code may succeed by retrying later.
+func isRetryable(code codes.Code) bool {
+	switch code {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+// statusError converts err returned by the server handlers into a gRPC status error
+// with the error details, so that clients can tell the cause of the failure.
+func statusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	code := errorCode(err)
+	if code == codes.Unknown {
+		code = codes.Internal
+	}
+	st, derr := status.New(code, err.Error()).WithDetails(&errdetails.ErrorInfo{
+		Reason: errorReason(err, code),
+		Domain: ErrorDomain,
+		Metadata: map[string]string{
+			"retryable": fmt.Sprint(isRetryable(code)),
+		},
+	})
+	if derr != nil {
+		return status.Errorf(codes.Internal, "failed to add error details: %s", derr)
+	}
+	return st.Err()
+}
+
+// rootStatus returns the innermost status of err, whose message is not prefixed by the wrapping errors.
+func rootStatus(err error) (*status.Status, bool) {
+	var se interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &se) {
+		return nil, false
+	}
+	return se.GRPCStatus(), true
+}
+
+func errorInfo(st *status.Status) *errdetails.ErrorInfo {
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.Domain == ErrorDomain {
+			return info
+		}
+	}
+	return nil
+}
+
+var reasonMessages = map[string]string{
+	ReasonFileNotFound:  "file not found",
+	ReasonFileExists:    "file already exists",
+	ReasonNoSpace:       "no space left on the server, retry later",
+	ReasonQuotaExceeded: "disk quota exceeded on the server, retry later",
+	ReasonIsDirectory:   "is a directory",
+	ReasonNotDirectory:  "not a directory",
+	ReasonNotEmpty:      "directory not empty, use --recursive to remove it",
+}
+
+var codeMessages = map[codes.Code]string{
+	codes.NotFound:           "not found",
+	codes.PermissionDenied:   "permission denied",
+	codes.Unauthenticated:    "authentication failed, check --token or --token-file",
+	codes.AlreadyExists:      "already exists",
+	codes.ResourceExhausted:  "resource exhausted on the server, retry later",
+	codes.Unavailable:        "server is unavailable, retry later",
+	codes.DeadlineExceeded:   "timed out, retry later",
+	codes.Aborted:            "aborted, retry later",
+	codes.DataLoss:           "transferred content was corrupted, retry the transfer",
+	codes.InvalidArgument:    "invalid request",
+	codes.OutOfRange:         "invalid request",
+	codes.FailedPrecondition: "invalid request",
+	codes.Unimplemented:      "not supported by the server",
+	codes.Canceled:           "canceled",
+	codes.Internal:           "server error",
+}
+
+// ErrorMessage returns a message of err for users.
+func ErrorMessage(err error) string {
+	if tlsHandshakeFailed(err) {
+		msg := err.Error()
+		if st, ok := rootStatus(err); ok {
+			msg = st.Message()
+		}
+		return "TLS handshake failed, check the server certificate (--ca-cert, known hosts) and the client certificate (--client-cert): " + msg
+	}
+	st, ok := rootStatus(err)
+	if !ok {
+		return err.Error()
+	}
+	msg := codeMessages[st.Code()]
+	if info := errorInfo(st); info != nil {
+		if m, ok := reasonMessages[info.Reason]; ok {
+			msg = m
+		}
+	}
+	if msg == "" {
+		return st.Message()
+	}
+	return msg + ": " + st.Message()
+}
+
+// ExitCode returns the exit code of the CLI for err.
+func ExitCode(err error) int {
+	code := errorCode(err)
+	switch code {
+	case codes.OK:
+		return ExitOK
+	case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition, codes.Unimplemented:
+		return ExitInvalid
+	case codes.NotFound:
+		return ExitNotFound
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return ExitPermission
+	case codes.AlreadyExists:
+		return ExitExists
+	case codes.DataLoss:
+		return ExitDataLoss
+	}
+	if isRetryable(code) {
+		return ExitTemporary
+	}
+	return ExitError
+}
@@ -0,0 +1,142 @@
+package grpcp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// openNoFollow opens the file like os.OpenFile, but refuses symbolic links and non-regular files.
+// The temporary files are not checked by resolvePath, so a planted symbolic link with their names
+// must not redirect the access to outside of the root directory.
+func openNoFollow(filename string, flag int, perm fs.FileMode) (*os.File, error) {
+	// check before opening, because opening a named pipe blocks
+	if st, err := os.Lstat(filename); err == nil && !st.Mode().IsRegular() {
+		return nil, status.Errorf(codes.PermissionDenied, "%s is not a regular file", filename)
+	}
+	f, err := os.OpenFile(filename, flag|oNoFollow, perm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if !st.Mode().IsRegular() {
+		f.Close()
+		return nil, status.Errorf(codes.PermissionDenied, "%s is not a regular file", filename)
+	}
+	return f, nil
+}
+
+// openFileAt opens the file for reading and writing, and seeks to offset.
+// The file must already have at least offset bytes, and the content after offset is truncated.
+func openFileAt(filename string, offset int64) (*os.File, error) {
+	f, err := openNoFollow(filename, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if offset < 0 || st.Size() < offset {
+		f.Close()
+		return nil, status.Errorf(codes.OutOfRange, "offset %d is out of range of the file size %d", offset, st.Size())
+	}
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to truncate file: %w", err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to seek file: %w", err)
+	}
+	return f, nil
+}
+
+// partialFilename returns the name of the partially transferred file for resuming.
+func partialFilename(filename string) string {
+	return filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+".grpcp-part")
+}
+
+// rangeFilename returns the name of the file to write the ranges of parallel upload.
+func rangeFilename(filename string) string {
+	return filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+".grpcp-ranges")
+}
+
+// openRangeFile opens the file to write the ranges of parallel upload.
+// The file is shared by the concurrent streams, which write their ranges at the offsets.
+func openRangeFile(filename string) (*os.File, error) {
+	return openNoFollow(rangeFilename(filename), os.O_WRONLY|os.O_CREATE, 0644)
+}
+
+// atomicFile is a temporary file in the same directory as the destination.
+// It is renamed to the destination by Commit, or removed by Abort.
+type atomicFile struct {
+	*os.File
+	path      string
+	keep      bool
+	committed bool
+}
+
+// createAtomicFile creates a temporary file for filename.
+// If resume is true, the partial file is reused from offset and kept on failure.
+func createAtomicFile(filename string, offset int64, resume bool) (*atomicFile, error) {
+	if resume {
+		f, err := openFileAt(partialFilename(filename), offset)
+		if err != nil {
+			return nil, err
+		}
+		return &atomicFile{File: f, path: filename, keep: true}, nil
+	}
+	if offset != 0 {
+		return nil, status.Errorf(codes.OutOfRange, "offset %d is out of range of the file size 0", offset)
+	}
+	for i := 0; ; i++ {
+		name := filepath.Join(filepath.Dir(filename), fmt.Sprintf(".%s.grpcp-%d", filepath.Base(filename), rand.Uint32()))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, fs.ErrExist) && i < 100 {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to create temporary file: %w", err)
+		}
+		return &atomicFile{File: f, path: filename}, nil
+	}
+}
+
+// Commit flushes the temporary file to the storage and renames it to the destination.
+func (f *atomicFile) Commit() error {
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+	f.committed = true
+	return nil
+}
+
+// Abort closes the temporary file and removes it unless it is kept for resuming.
+// It does nothing after Commit.
+func (f *atomicFile) Abort() {
+	if f.committed {
+		return
+	}
+	f.Close()
+	if !f.keep {
+		os.Remove(f.Name())
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !unix
+
+package grpcp
+
+// oNoFollow is not supported. openNoFollow checks symbolic links by os.Lstat only.
+const oNoFollow = 0
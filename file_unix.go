@@ -0,0 +1,8 @@
+//go:build unix
+
+package grpcp
+
+import "syscall"
+
+// oNoFollow makes os.OpenFile fail if the last component of the path is a symbolic link.
+const oNoFollow = syscall.O_NOFOLLOW
@@ -0,0 +1,113 @@
+package grpcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	pb "github.com/fujiwara/grpcp/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FileInfo is an entry of the remote directory listed by Client.List.
+type FileInfo struct {
+	Name    string
+	Size    int64
+	Mode    fs.FileMode
+	ModTime time.Time
+}
+
+func (fi *FileInfo) IsDir() bool {
+	return fi.Mode.IsDir()
+}
+
+// dialRemote connects to the host of the remote path "host:/path", and returns the client and the path on the host.
+func (c *Client) dialRemote(remotePath string) (pb.FileTransferServiceClient, string, func() error, error) {
+	host, filename := parseFilename(remotePath)
+	if host == "" {
+		return nil, "", nil, status.Errorf(codes.InvalidArgument, "remote path is required (host:/path): %s", remotePath)
+	}
+	client, close, err := c.newGRPCClient(c.addr(host))
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return client, filename, close, nil
+}
+
+// List returns the entries of the remote directory, or the remote file itself.
+func (c *Client) List(ctx context.Context, remotePath string) ([]*FileInfo, error) {
+	client, filename, close, err := c.dialRemote(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+	stream, err := client.List(ctx, &pb.ListRequest{Filename: filename})
+	if err != nil {
+		return nil, fmt.Errorf("failed to new list stream: %w", err)
+	}
+	var entries []*FileInfo
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", filename, err)
+		}
+		entries = append(entries, &FileInfo{
+			Name:    res.Filename,
+			Size:    res.Size,
+			Mode:    fs.FileMode(res.Mode),
+			ModTime: time.Unix(0, res.Mtime),
+		})
+	}
+}
+
+// Remove removes the remote file, or the remote directory with its contents if recursive is true.
+func (c *Client) Remove(ctx context.Context, remotePath string, recursive bool) error {
+	client, filename, close, err := c.dialRemote(remotePath)
+	if err != nil {
+		return err
+	}
+	defer close()
+	if _, err := client.Remove(ctx, &pb.RemoveRequest{Filename: filename, Recursive: recursive}); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", filename, err)
+	}
+	return nil
+}
+
+// Mkdir creates the remote directory, and its parents if parents is true.
+func (c *Client) Mkdir(ctx context.Context, remotePath string, parents bool) error {
+	client, filename, close, err := c.dialRemote(remotePath)
+	if err != nil {
+		return err
+	}
+	defer close()
+	if _, err := client.Mkdir(ctx, &pb.MkdirRequest{Filename: filename, Parents: parents}); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filename, err)
+	}
+	return nil
+}
+
+// Rename renames the remote file. dest is a path on the same host, with or without the "host:" prefix.
+// If dest is an existing directory, src is moved into it.
+func (c *Client) Rename(ctx context.Context, remoteSrc, dest string) error {
+	client, src, close, err := c.dialRemote(remoteSrc)
+	if err != nil {
+		return err
+	}
+	defer close()
+	srcHost, _ := parseFilename(remoteSrc)
+	if destHost, destFile := parseFilename(dest); destHost != "" {
+		if c.addr(destHost) != c.addr(srcHost) {
+			return status.Errorf(codes.InvalidArgument, "cannot rename to another host: %s", dest)
+		}
+		dest = destFile
+	}
+	if _, err := client.Rename(ctx, &pb.RenameRequest{Src: src, Dest: dest}); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", src, err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package grpcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+
+	pb "github.com/fujiwara/grpcp/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// hasGlobMeta reports whether the path contains any of the special characters of path.Match.
+func hasGlobMeta(p string) bool {
+	return strings.ContainsAny(p, `*?[\`)
+}
+
+// downloadGlob downloads the remote files matched by the pattern into localDir.
+// The pattern is expanded by the server.
+func downloadGlob(ctx context.Context, client pb.FileTransferServiceClient, pattern, localDir string, opt *ClientOption) error {
+	var entries []*pb.GlobResponse
+	err := opt.Retry.do(ctx, func(error) (err error) {
+		entries, err = globRemote(ctx, client, pattern)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	transfer := func(entry *pb.GlobResponse) error {
+		if entry.IsDir && opt.Recursive {
+			return downloadDir(ctx, client, entry.Filename, localDir, opt)
+		}
+		return retryTransfer(downloadFile)(ctx, client, entry.Filename, localDir, opt)
+	}
+	switch len(entries) {
+	case 0:
+		return status.Errorf(codes.NotFound, "no files match %s", pattern)
+	case 1:
+		// same as the literal filename
+		return transfer(entries[0])
+	}
+	if st, err := os.Stat(localDir); err != nil || !st.IsDir() {
+		return status.Errorf(codes.InvalidArgument, "destination must be a directory to copy multiple files: %s", localDir)
+	}
+
+	var summary copySummary
+	names := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir && !opt.Recursive {
+			slog.Warn("skipping directory without -r", "remote", entry.Filename)
+			continue
+		}
+		// matches in the different directories may have the same name
+		name := path.Base(entry.Filename)
+		if prev, ok := names[name]; ok {
+			err := status.Errorf(codes.AlreadyExists, "%s has the same name as %s", entry.Filename, prev)
+			slog.Error("failed to download", "remote", entry.Filename, "error", err)
+			summary.Errors = append(summary.Errors, err)
+			continue
+		}
+		names[name] = entry.Filename
+		if err := transfer(entry); err != nil {
+			slog.Error("failed to download", "remote", entry.Filename, "error", err)
+			summary.Errors = append(summary.Errors, fmt.Errorf("%s: %w", entry.Filename, err))
+			continue
+		}
+		if entry.IsDir {
+			summary.Dirs++
+		} else {
+			summary.Files++
+			summary.Bytes += entry.Size
+		}
+	}
+	return summary.log("glob copy completed")
+}
+
+// globRemote returns the remote files matched by the pattern.
+func globRemote(ctx context.Context, client pb.FileTransferServiceClient, pattern string) ([]*pb.GlobResponse, error) {
+	stream, err := client.Glob(ctx, &pb.GlobRequest{Pattern: pattern})
+	if err != nil {
+		return nil, fmt.Errorf("failed to new glob stream: %w", err)
+	}
+	var entries []*pb.GlobResponse
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to glob %s: %w", pattern, err)
+		}
+		entries = append(entries, res)
+	}
+}
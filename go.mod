@@ -6,17 +6,23 @@ toolchain go1.23.0
 
 require (
 	github.com/alecthomas/kong v0.9.0
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/klauspost/compress v1.17.9
 	github.com/schollz/progressbar/v3 v3.14.6
+	github.com/zeebo/blake3 v0.2.4
+	golang.org/x/sync v0.8.0
+	golang.org/x/time v0.6.0
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240814211410-ddb44dafa142
 	google.golang.org/grpc v1.65.0
 	google.golang.org/protobuf v1.34.2
 )
 
 require (
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
 	golang.org/x/net v0.28.0 // indirect
 	golang.org/x/sys v0.24.0 // indirect
 	golang.org/x/term v0.23.0 // indirect
 	golang.org/x/text v0.17.0 // indirect
-	google.golang.org/genproto/googleapis/rpc v0.0.0-20240814211410-ddb44dafa142 // indirect
 )
@@ -0,0 +1,85 @@
+package grpcp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var knownHostsMu sync.Mutex
+
+// errCertificateChanged is returned when the server certificate does not match the pinned fingerprint.
+var errCertificateChanged = errors.New("server certificate has changed")
+
+// verifyKnownHost verifies the server certificate by the fingerprint pinned in the known hosts file.
+// If the server is not known yet, its fingerprint is added to the file (trust on first use).
+func verifyKnownHost(filename, addr string, rawCerts [][]byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no server certificate presented")
+	}
+	fingerprint := certFingerprint(rawCerts[0])
+
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+	known, err := readKnownHosts(filename)
+	if err != nil {
+		return err
+	}
+	if pinned, ok := known[addr]; ok {
+		if pinned != fingerprint {
+			return fmt.Errorf(
+				"%w: %s (pinned %s, got %s). it may be a man-in-the-middle attack. if the change is expected, remove the line of %s from %s",
+				errCertificateChanged, addr, pinned, fingerprint, addr, filename,
+			)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return fmt.Errorf("failed to create directory for known hosts: %w", err)
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open known hosts: %w", err)
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%s %s\n", addr, fingerprint); err != nil {
+		return fmt.Errorf("failed to write known hosts: %w", err)
+	}
+	slog.Warn("permanently added the server certificate to known hosts", "addr", addr, "fingerprint", fingerprint, "file", filename)
+	return nil
+}
+
+// readKnownHosts reads the known hosts file. Each line of the file is "host:port fingerprint".
+func readKnownHosts(filename string) (map[string]string, error) {
+	known := make(map[string]string)
+	f, err := os.Open(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return known, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open known hosts: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid line in %s: %s", filename, line)
+		}
+		known[fields[0]] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read known hosts: %w", err)
+	}
+	return known, nil
+}
@@ -0,0 +1,49 @@
+package grpcp
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"time"
+
+	pb "github.com/fujiwara/grpcp/proto"
+)
+
+// newMetadata returns the metadata of the file to be preserved.
+func newMetadata(st fs.FileInfo) *pb.FileMetadata {
+	md := &pb.FileMetadata{
+		Mode:  uint32(st.Mode().Perm()),
+		Mtime: st.ModTime().UnixNano(),
+		Atime: fileAtime(st).UnixNano(),
+	}
+	if uid, gid, ok := fileOwner(st); ok {
+		md.HasOwner = true
+		md.Uid = uid
+		md.Gid = gid
+	}
+	return md
+}
+
+// applyMetadata applies the metadata to the file. The owner is applied only if owner is true.
+// Changing the owner is best effort, because it requires the privilege in most cases.
+func applyMetadata(f *os.File, md *pb.FileMetadata, owner bool) error {
+	if md == nil {
+		return nil
+	}
+	if err := f.Chmod(fs.FileMode(md.Mode).Perm()); err != nil {
+		return fmt.Errorf("failed to change mode: %w", err)
+	}
+	if md.HasOwner && owner {
+		if err := f.Chown(int(md.Uid), int(md.Gid)); errors.Is(err, fs.ErrPermission) {
+			slog.Warn("failed to change owner", "filename", f.Name(), "uid", md.Uid, "gid", md.Gid, "error", err)
+		} else if err != nil {
+			return fmt.Errorf("failed to change owner: %w", err)
+		}
+	}
+	if err := os.Chtimes(f.Name(), time.Unix(0, md.Atime), time.Unix(0, md.Mtime)); err != nil {
+		return fmt.Errorf("failed to change times: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package grpcp
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+func fileAtime(st fs.FileInfo) time.Time {
+	if s, ok := st.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(s.Atimespec.Unix())
+	}
+	return st.ModTime()
+}
+
+func fileOwner(st fs.FileInfo) (uint32, uint32, bool) {
+	if s, ok := st.Sys().(*syscall.Stat_t); ok {
+		return s.Uid, s.Gid, true
+	}
+	return 0, 0, false
+}
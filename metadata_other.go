@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package grpcp
+
+import (
+	"io/fs"
+	"time"
+)
+
+func fileAtime(st fs.FileInfo) time.Time {
+	return st.ModTime()
+}
+
+func fileOwner(st fs.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,50 @@
+//go:build unix
+
+package grpcp_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/fujiwara/grpcp"
+)
+
+func TestPreserveOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner requires root")
+	}
+	dir := t.TempDir()
+	testRemote := filepath.Join(dir, "remote.txt")
+	if err := os.WriteFile(testRemote, []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	const uid, gid = 12345, 23456
+	if err := os.Chown(testRemote, uid, gid); err != nil {
+		t.Fatalf("failed to change owner: %s", err)
+	}
+
+	// the owner claimed by the server is applied only with PreserveOwner
+	for _, owner := range []bool{false, true} {
+		client := grpcp.NewClient(&grpcp.ClientOption{
+			Port:          testPort(false),
+			Quiet:         true,
+			Preserve:      true,
+			PreserveOwner: owner,
+		})
+		testLocal := filepath.Join(dir, "local.txt")
+		if err := client.Copy(context.Background(), testHost+":"+testRemote, testLocal); err != nil {
+			t.Fatalf("failed to download: %s", err)
+		}
+		st, err := os.Stat(testLocal)
+		if err != nil {
+			t.Fatalf("failed to stat: %s", err)
+		}
+		sys := st.Sys().(*syscall.Stat_t)
+		if owned := sys.Uid == uid && sys.Gid == gid; owned != owner {
+			t.Errorf("PreserveOwner=%v: unexpected owner %d:%d", owner, sys.Uid, sys.Gid)
+		}
+	}
+}
@@ -1,17 +1,84 @@
 package grpcp
 
+import (
+	"io"
+	"os"
+	"time"
+)
+
 type ServerOption struct {
-	Port     int    `json:"port"`
-	Listen   string `json:"listen"`
-	TLS      bool   `json:"tls"`
-	CertFile string `json:"cert_file"`
-	KeyFile  string `json:"key_file"`
+	Port               int           `json:"port"`
+	Listen             string        `json:"listen"`
+	TLS                bool          `json:"tls"`
+	CertFile           string        `json:"cert_file"`
+	KeyFile            string        `json:"key_file"`
+	Root               string        `json:"root"`
+	TokenFile          string        `json:"token_file"`
+	ClientCAFile       string        `json:"client_ca_file"`
+	CertDir            string        `json:"cert_dir"`
+	CertHosts          []string      `json:"cert_hosts"`
+	CertReloadInterval time.Duration `json:"cert_reload_interval"`
+	// AllowRemoteShutdown enables the Shutdown RPC for the clients in AdminNames.
+	AllowRemoteShutdown bool `json:"allow_remote_shutdown"`
+	// AdminNames are token names ("token:NAME") or client certificate common names ("cn:NAME") allowed to shut down the server.
+	AdminNames []string `json:"admin_names"`
+	// ShutdownTimeout is the time to wait for the active transfers on shutdown. 0 waits indefinitely.
+	ShutdownTimeout time.Duration `json:"shutdown_timeout"`
+	// RateLimit is the bandwidth limit of all transfers in bytes per second. 0 means no limit.
+	RateLimit int64 `json:"rate_limit"`
+	// ConnRateLimit is the bandwidth limit of each client connection in bytes per second. 0 means no limit.
+	ConnRateLimit int64 `json:"conn_rate_limit"`
+	// PreserveOwner applies the owner and group of the files uploaded with --preserve.
+	// Otherwise the uploaded files are owned by the server process.
+	PreserveOwner bool `json:"preserve_owner"`
 }
 
 type ClientOption struct {
-	Host       string `json:"host"`
-	Port       int    `json:"port"`
-	Quiet      bool   `json:"quiet"`
-	TLS        bool   `json:"tls"`
-	SkipVerify bool   `json:"skip_verify"`
+	Host           string      `json:"host"`
+	Port           int         `json:"port"`
+	Quiet          bool        `json:"quiet"`
+	TLS            bool        `json:"tls"`
+	SkipVerify     bool        `json:"skip_verify"`
+	Resume         bool        `json:"resume"`
+	Checksum       string      `json:"checksum"`
+	Recursive      bool        `json:"recursive"`
+	Preserve       bool        `json:"preserve"`
+	PreserveOwner  bool        `json:"preserve_owner"`
+	Token          string      `json:"token"`
+	CertFile       string      `json:"cert_file"`
+	KeyFile        string      `json:"key_file"`
+	CACertFile     string      `json:"ca_cert_file"`
+	ServerName     string      `json:"server_name"`
+	KnownHostsFile string      `json:"known_hosts_file"`
+	Retry          RetryPolicy `json:"retry"`
+	Compress       string      `json:"compress"`
+	// LimitRate is the bandwidth limit in bytes per second. 0 means no limit.
+	LimitRate int64 `json:"limit_rate"`
+	// Parallel is the number of concurrent streams to transfer a large file in ranges.
+	Parallel int `json:"parallel"`
+	// Connections is the number of connections to the server, which the streams are distributed over.
+	Connections int `json:"connections"`
+	// Stdin and Stdout are used for the source and destination "-". The defaults are os.Stdin and os.Stdout.
+	Stdin  io.Reader `json:"-"`
+	Stdout io.Writer `json:"-"`
+}
+
+// keepPartial reports whether the partially transferred file is kept on failure,
+// to resume the transfer by --resume or retries.
+func (o *ClientOption) keepPartial() bool {
+	return o.Resume || o.Retry.enabled()
+}
+
+func (o *ClientOption) stdin() io.Reader {
+	if o.Stdin != nil {
+		return o.Stdin
+	}
+	return os.Stdin
+}
+
+func (o *ClientOption) stdout() io.Writer {
+	if o.Stdout != nil {
+		return o.Stdout
+	}
+	return os.Stdout
 }
@@ -0,0 +1,317 @@
+package grpcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	pb "github.com/fujiwara/grpcp/proto"
+	"github.com/schollz/progressbar/v3"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fileRange is a range of a file transferred by a stream of parallel transfer.
+type fileRange struct {
+	offset int64
+	length int64
+}
+
+// splitRanges splits the file of size into at most n ranges.
+// Each range is at least StreamBufferSize bytes, so small files are not split.
+func splitRanges(size int64, n int) []fileRange {
+	n = int(min(int64(n), size/int64(StreamBufferSize)))
+	if n <= 1 {
+		return nil
+	}
+	ranges := make([]fileRange, 0, n)
+	length := (size + int64(n) - 1) / int64(n)
+	for offset := int64(0); offset < size; offset += length {
+		ranges = append(ranges, fileRange{offset: offset, length: min(length, size-offset)})
+	}
+	return ranges
+}
+
+func newProgressWriter(quiet bool, size int64, description string) io.Writer {
+	if quiet {
+		return io.Discard
+	}
+	return progressbar.DefaultBytes(size, description)
+}
+
+// uploadParallel uploads the ranges of the file over concurrent streams.
+// The server writes the ranges into a temporary file, which is committed after all the ranges are uploaded.
+func uploadParallel(ctx context.Context, client pb.FileTransferServiceClient, remoteFile string, file *os.File, st os.FileInfo, ranges []fileRange, opt *ClientOption) error {
+	slog.Info("starting parallel upload", "local", file.Name(), "remote", remoteFile, "bytes", st.Size(), "streams", len(ranges))
+	var compressions []string
+	if opt.Compress != "" && opt.Compress != CompressionNone {
+		var err error
+		if compressions, err = serverCompressions(ctx, client); err != nil {
+			return err
+		}
+	}
+	bar := newProgressWriter(opt.Quiet, st.Size(), "uploading")
+	limiter := newLimiter(opt.LimitRate)
+
+	algo := checksumAlgorithm(opt.Checksum)
+	h, err := newHash(algo)
+	if err != nil {
+		return err
+	}
+	eg, egctx := errgroup.WithContext(ctx)
+	// the checksum of the whole file is verified at commit
+	eg.Go(func() error {
+		return hashPrefix(h, file, st.Size())
+	})
+	for _, rng := range ranges {
+		eg.Go(func() error {
+			return opt.Retry.do(egctx, func(error) error {
+				return uploadRange(egctx, client, remoteFile, file, st.Size(), rng, compressions, limiter, bar, opt)
+			})
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		// remove the uploaded ranges on the server. the transfer is not resumable
+		if _, aerr := client.CommitUpload(context.WithoutCancel(ctx), &pb.CommitUploadRequest{Filename: remoteFile, Abort: true}); aerr != nil {
+			slog.Warn("failed to abort parallel upload", "remote", remoteFile, "error", aerr)
+		}
+		return err
+	}
+	req := &pb.CommitUploadRequest{
+		Filename:          remoteFile,
+		Size:              st.Size(),
+		ChecksumAlgorithm: algo,
+		Checksum:          sum(h),
+	}
+	if opt.Preserve {
+		req.Metadata = newMetadata(st)
+	}
+	if _, err := client.CommitUpload(ctx, req); err != nil {
+		return fmt.Errorf("failed to commit upload: %w", err)
+	}
+	slog.Info("client parallel upload completed", "bytes", st.Size())
+	return nil
+}
+
+func uploadRange(ctx context.Context, client pb.FileTransferServiceClient, remoteFile string, file *os.File, size int64, rng fileRange, compressions []string, limiter *rate.Limiter, bar io.Writer, opt *ClientOption) error {
+	algo := checksumAlgorithm(opt.Checksum)
+	h, err := newHash(algo)
+	if err != nil {
+		return err
+	}
+	c, err := newCompressor(opt.Compress, compressions)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	var r io.Reader = io.NewSectionReader(file, rng.offset, rng.length)
+	if h != nil {
+		r = io.TeeReader(r, h)
+	}
+
+	stream, err := client.Upload(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to new upload stream: %w", err)
+	}
+	totalBytes := int64(0)
+	buf := make([]byte, StreamBufferSize)
+	for {
+		n, err := r.Read(buf)
+		if err == io.EOF {
+			if totalBytes != rng.length {
+				return status.Errorf(codes.DataLoss, "range size mismatch: expected %d bytes, got %d bytes", rng.length, totalBytes)
+			}
+			if h == nil {
+				break
+			}
+			// send the last message with the checksum of the range
+			req := &pb.FileUploadRequest{Filename: remoteFile, Size: size, Checksum: sum(h)}
+			if err := stream.Send(req); err != nil && err != io.EOF {
+				return fmt.Errorf("failed to send file: %w", err)
+			}
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		req := &pb.FileUploadRequest{Filename: remoteFile, Size: size}
+		if totalBytes == 0 {
+			req.Offset = rng.offset
+			req.Length = rng.length
+			req.ChecksumAlgorithm = algo
+		}
+		if req.Content, req.Compression, err = c.compress(buf[:n]); err != nil {
+			return err
+		}
+		if err := waitLimiters(ctx, len(req.Content), limiter); err != nil {
+			return err
+		}
+		if err := stream.Send(req); err == io.EOF {
+			// the server closed the stream. the actual error is returned by CloseAndRecv
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to send file: %w", err)
+		}
+		bar.Write(buf[:n])
+		totalBytes += int64(n)
+	}
+	if _, err := stream.CloseAndRecv(); err != nil {
+		return fmt.Errorf("failed to receive response: %w", err)
+	}
+	return nil
+}
+
+// downloadParallel downloads the ranges of the remote file over concurrent streams,
+// and writes them into a temporary file at their offsets.
+func downloadParallel(ctx context.Context, client pb.FileTransferServiceClient, remoteFile, localFile string, size int64, ranges []fileRange, opt *ClientOption) error {
+	slog.Info("starting parallel download", "remote", remoteFile, "local", localFile, "bytes", size, "streams", len(ranges))
+	f, err := createAtomicFile(localFile, 0, false)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+	if err := f.Truncate(size); err != nil {
+		return fmt.Errorf("failed to truncate file: %w", err)
+	}
+	bar := newProgressWriter(opt.Quiet, size, "downloading")
+	limiter := newLimiter(opt.LimitRate)
+
+	// the metadata of each range is compared to detect the remote file changed between the ranges
+	mds := make([]*pb.FileMetadata, len(ranges))
+	eg, egctx := errgroup.WithContext(ctx)
+	for i, rng := range ranges {
+		eg.Go(func() error {
+			return opt.Retry.do(egctx, func(error) (err error) {
+				mds[i], err = downloadRange(egctx, client, remoteFile, f.File, size, rng, limiter, bar, opt)
+				return err
+			})
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	var last *pb.FileMetadata
+	err = opt.Retry.do(ctx, func(error) (err error) {
+		last, err = remoteMetadata(ctx, client, remoteFile, size)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, md := range mds {
+		if md == nil || md.Mtime != last.Mtime {
+			return status.Errorf(codes.DataLoss, "remote file %s was modified during the parallel download", remoteFile)
+		}
+	}
+	if opt.Preserve {
+		if err := applyMetadata(f.File, last, opt.PreserveOwner); err != nil {
+			return err
+		}
+	}
+	if err := f.Commit(); err != nil {
+		return err
+	}
+	slog.Info("client parallel download completed", "bytes", size)
+	return nil
+}
+
+// remoteMetadata returns the metadata of remoteFile by downloading nothing at the end of the file.
+// The file size must be size.
+func remoteMetadata(ctx context.Context, client pb.FileTransferServiceClient, remoteFile string, size int64) (*pb.FileMetadata, error) {
+	stream, err := client.Download(ctx, &pb.FileDownloadRequest{
+		Filename: remoteFile,
+		Offset:   size,
+		Preserve: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to new download stream: %w", err)
+	}
+	var metadata *pb.FileMetadata
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			if metadata == nil {
+				return nil, status.Errorf(codes.DataLoss, "metadata of %s was not received", remoteFile)
+			}
+			return metadata, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to receive response: %w", err)
+		}
+		if res.Size != size {
+			return nil, status.Errorf(codes.DataLoss, "remote file %s was modified during the parallel download: expected %d bytes, got %d bytes", remoteFile, size, res.Size)
+		}
+		if res.Metadata != nil {
+			metadata = res.Metadata
+		}
+	}
+}
+
+// downloadRange downloads rng of remoteFile of size into f, and returns the metadata of remoteFile.
+func downloadRange(ctx context.Context, client pb.FileTransferServiceClient, remoteFile string, f *os.File, size int64, rng fileRange, limiter *rate.Limiter, bar io.Writer, opt *ClientOption) (*pb.FileMetadata, error) {
+	algo := checksumAlgorithm(opt.Checksum)
+	h, err := newHash(algo)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := client.Download(ctx, &pb.FileDownloadRequest{
+		Filename:          remoteFile,
+		Offset:            rng.offset,
+		Length:            rng.length,
+		ChecksumAlgorithm: algo,
+		Preserve:          true,
+		Compression:       opt.Compress,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to new download stream: %w", err)
+	}
+	var w io.Writer = io.NewOffsetWriter(f, rng.offset)
+	if h != nil {
+		w = io.MultiWriter(w, h)
+	}
+	d := &decompressor{}
+	defer d.Close()
+	var checksum []byte
+	var metadata *pb.FileMetadata
+	totalBytes := int64(0)
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			if totalBytes != rng.length {
+				return nil, status.Errorf(codes.DataLoss, "range size mismatch: expected %d bytes, got %d bytes", rng.length, totalBytes)
+			}
+			if err := verifyChecksum(h, checksum); err != nil {
+				return nil, err
+			}
+			return metadata, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to receive response: %w", err)
+		}
+		if res.Size != size {
+			return nil, status.Errorf(codes.DataLoss, "remote file %s was modified during the parallel download: expected %d bytes, got %d bytes", remoteFile, size, res.Size)
+		}
+		if res.Checksum != nil {
+			checksum = res.Checksum
+		}
+		if res.Metadata != nil {
+			metadata = res.Metadata
+		}
+		if err := waitLimiters(ctx, len(res.Content), limiter); err != nil {
+			return nil, err
+		}
+		content, err := d.decompress(res.Content, res.Compression)
+		if err != nil {
+			return nil, err
+		}
+		if totalBytes+int64(len(content)) > rng.length {
+			return nil, status.Errorf(codes.DataLoss, "received more than the range of %d bytes", rng.length)
+		}
+		if _, err := w.Write(content); err != nil {
+			return nil, fmt.Errorf("failed to write file: %w", err)
+		}
+		bar.Write(content)
+		totalBytes += int64(len(content))
+	}
+}
@@ -24,6 +24,14 @@ const _ = grpc.SupportPackageIsVersion7
 type FileTransferServiceClient interface {
 	Upload(ctx context.Context, opts ...grpc.CallOption) (FileTransferService_UploadClient, error)
 	Download(ctx context.Context, in *FileDownloadRequest, opts ...grpc.CallOption) (FileTransferService_DownloadClient, error)
+	Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*StatResponse, error)
+	Mkdir(ctx context.Context, in *MkdirRequest, opts ...grpc.CallOption) (*MkdirResponse, error)
+	Walk(ctx context.Context, in *WalkRequest, opts ...grpc.CallOption) (FileTransferService_WalkClient, error)
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (FileTransferService_ListClient, error)
+	Glob(ctx context.Context, in *GlobRequest, opts ...grpc.CallOption) (FileTransferService_GlobClient, error)
+	Remove(ctx context.Context, in *RemoveRequest, opts ...grpc.CallOption) (*RemoveResponse, error)
+	Rename(ctx context.Context, in *RenameRequest, opts ...grpc.CallOption) (*RenameResponse, error)
+	CommitUpload(ctx context.Context, in *CommitUploadRequest, opts ...grpc.CallOption) (*CommitUploadResponse, error)
 	Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error)
 	Shutdown(ctx context.Context, in *ShutdownRequest, opts ...grpc.CallOption) (*ShutdownResponse, error)
 }
@@ -102,6 +110,147 @@ func (x *fileTransferServiceDownloadClient) Recv() (*FileDownloadResponse, error
 	return m, nil
 }
 
+func (c *fileTransferServiceClient) Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*StatResponse, error) {
+	out := new(StatResponse)
+	err := c.cc.Invoke(ctx, "/grpcp.FileTransferService/Stat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileTransferServiceClient) Mkdir(ctx context.Context, in *MkdirRequest, opts ...grpc.CallOption) (*MkdirResponse, error) {
+	out := new(MkdirResponse)
+	err := c.cc.Invoke(ctx, "/grpcp.FileTransferService/Mkdir", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileTransferServiceClient) Walk(ctx context.Context, in *WalkRequest, opts ...grpc.CallOption) (FileTransferService_WalkClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileTransferService_ServiceDesc.Streams[2], "/grpcp.FileTransferService/Walk", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileTransferServiceWalkClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileTransferService_WalkClient interface {
+	Recv() (*WalkResponse, error)
+	grpc.ClientStream
+}
+
+type fileTransferServiceWalkClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileTransferServiceWalkClient) Recv() (*WalkResponse, error) {
+	m := new(WalkResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileTransferServiceClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (FileTransferService_ListClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileTransferService_ServiceDesc.Streams[3], "/grpcp.FileTransferService/List", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileTransferServiceListClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileTransferService_ListClient interface {
+	Recv() (*ListResponse, error)
+	grpc.ClientStream
+}
+
+type fileTransferServiceListClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileTransferServiceListClient) Recv() (*ListResponse, error) {
+	m := new(ListResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileTransferServiceClient) Glob(ctx context.Context, in *GlobRequest, opts ...grpc.CallOption) (FileTransferService_GlobClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileTransferService_ServiceDesc.Streams[4], "/grpcp.FileTransferService/Glob", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileTransferServiceGlobClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileTransferService_GlobClient interface {
+	Recv() (*GlobResponse, error)
+	grpc.ClientStream
+}
+
+type fileTransferServiceGlobClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileTransferServiceGlobClient) Recv() (*GlobResponse, error) {
+	m := new(GlobResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileTransferServiceClient) Remove(ctx context.Context, in *RemoveRequest, opts ...grpc.CallOption) (*RemoveResponse, error) {
+	out := new(RemoveResponse)
+	err := c.cc.Invoke(ctx, "/grpcp.FileTransferService/Remove", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileTransferServiceClient) Rename(ctx context.Context, in *RenameRequest, opts ...grpc.CallOption) (*RenameResponse, error) {
+	out := new(RenameResponse)
+	err := c.cc.Invoke(ctx, "/grpcp.FileTransferService/Rename", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileTransferServiceClient) CommitUpload(ctx context.Context, in *CommitUploadRequest, opts ...grpc.CallOption) (*CommitUploadResponse, error) {
+	out := new(CommitUploadResponse)
+	err := c.cc.Invoke(ctx, "/grpcp.FileTransferService/CommitUpload", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *fileTransferServiceClient) Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error) {
 	out := new(PingResponse)
 	err := c.cc.Invoke(ctx, "/grpcp.FileTransferService/Ping", in, out, opts...)
@@ -126,6 +275,14 @@ func (c *fileTransferServiceClient) Shutdown(ctx context.Context, in *ShutdownRe
 type FileTransferServiceServer interface {
 	Upload(FileTransferService_UploadServer) error
 	Download(*FileDownloadRequest, FileTransferService_DownloadServer) error
+	Stat(context.Context, *StatRequest) (*StatResponse, error)
+	Mkdir(context.Context, *MkdirRequest) (*MkdirResponse, error)
+	Walk(*WalkRequest, FileTransferService_WalkServer) error
+	List(*ListRequest, FileTransferService_ListServer) error
+	Glob(*GlobRequest, FileTransferService_GlobServer) error
+	Remove(context.Context, *RemoveRequest) (*RemoveResponse, error)
+	Rename(context.Context, *RenameRequest) (*RenameResponse, error)
+	CommitUpload(context.Context, *CommitUploadRequest) (*CommitUploadResponse, error)
 	Ping(context.Context, *PingRequest) (*PingResponse, error)
 	Shutdown(context.Context, *ShutdownRequest) (*ShutdownResponse, error)
 	mustEmbedUnimplementedFileTransferServiceServer()
@@ -141,6 +298,30 @@ func (UnimplementedFileTransferServiceServer) Upload(FileTransferService_UploadS
 func (UnimplementedFileTransferServiceServer) Download(*FileDownloadRequest, FileTransferService_DownloadServer) error {
 	return status.Errorf(codes.Unimplemented, "method Download not implemented")
 }
+func (UnimplementedFileTransferServiceServer) Stat(context.Context, *StatRequest) (*StatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stat not implemented")
+}
+func (UnimplementedFileTransferServiceServer) Mkdir(context.Context, *MkdirRequest) (*MkdirResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Mkdir not implemented")
+}
+func (UnimplementedFileTransferServiceServer) Walk(*WalkRequest, FileTransferService_WalkServer) error {
+	return status.Errorf(codes.Unimplemented, "method Walk not implemented")
+}
+func (UnimplementedFileTransferServiceServer) List(*ListRequest, FileTransferService_ListServer) error {
+	return status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedFileTransferServiceServer) Glob(*GlobRequest, FileTransferService_GlobServer) error {
+	return status.Errorf(codes.Unimplemented, "method Glob not implemented")
+}
+func (UnimplementedFileTransferServiceServer) Remove(context.Context, *RemoveRequest) (*RemoveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Remove not implemented")
+}
+func (UnimplementedFileTransferServiceServer) Rename(context.Context, *RenameRequest) (*RenameResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Rename not implemented")
+}
+func (UnimplementedFileTransferServiceServer) CommitUpload(context.Context, *CommitUploadRequest) (*CommitUploadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CommitUpload not implemented")
+}
 func (UnimplementedFileTransferServiceServer) Ping(context.Context, *PingRequest) (*PingResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
 }
@@ -207,6 +388,159 @@ func (x *fileTransferServiceDownloadServer) Send(m *FileDownloadResponse) error
 	return x.ServerStream.SendMsg(m)
 }
 
+func _FileTransferService_Stat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTransferServiceServer).Stat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpcp.FileTransferService/Stat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTransferServiceServer).Stat(ctx, req.(*StatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileTransferService_Mkdir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MkdirRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTransferServiceServer).Mkdir(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpcp.FileTransferService/Mkdir",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTransferServiceServer).Mkdir(ctx, req.(*MkdirRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileTransferService_Walk_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WalkRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileTransferServiceServer).Walk(m, &fileTransferServiceWalkServer{stream})
+}
+
+type FileTransferService_WalkServer interface {
+	Send(*WalkResponse) error
+	grpc.ServerStream
+}
+
+type fileTransferServiceWalkServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileTransferServiceWalkServer) Send(m *WalkResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileTransferService_List_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileTransferServiceServer).List(m, &fileTransferServiceListServer{stream})
+}
+
+type FileTransferService_ListServer interface {
+	Send(*ListResponse) error
+	grpc.ServerStream
+}
+
+type fileTransferServiceListServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileTransferServiceListServer) Send(m *ListResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileTransferService_Glob_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GlobRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileTransferServiceServer).Glob(m, &fileTransferServiceGlobServer{stream})
+}
+
+type FileTransferService_GlobServer interface {
+	Send(*GlobResponse) error
+	grpc.ServerStream
+}
+
+type fileTransferServiceGlobServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileTransferServiceGlobServer) Send(m *GlobResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileTransferService_Remove_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTransferServiceServer).Remove(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpcp.FileTransferService/Remove",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTransferServiceServer).Remove(ctx, req.(*RemoveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileTransferService_Rename_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenameRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTransferServiceServer).Rename(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpcp.FileTransferService/Rename",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTransferServiceServer).Rename(ctx, req.(*RenameRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileTransferService_CommitUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CommitUploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTransferServiceServer).CommitUpload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpcp.FileTransferService/CommitUpload",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTransferServiceServer).CommitUpload(ctx, req.(*CommitUploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _FileTransferService_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(PingRequest)
 	if err := dec(in); err != nil {
@@ -250,6 +584,26 @@ var FileTransferService_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "grpcp.FileTransferService",
 	HandlerType: (*FileTransferServiceServer)(nil),
 	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Stat",
+			Handler:    _FileTransferService_Stat_Handler,
+		},
+		{
+			MethodName: "Mkdir",
+			Handler:    _FileTransferService_Mkdir_Handler,
+		},
+		{
+			MethodName: "Remove",
+			Handler:    _FileTransferService_Remove_Handler,
+		},
+		{
+			MethodName: "Rename",
+			Handler:    _FileTransferService_Rename_Handler,
+		},
+		{
+			MethodName: "CommitUpload",
+			Handler:    _FileTransferService_CommitUpload_Handler,
+		},
 		{
 			MethodName: "Ping",
 			Handler:    _FileTransferService_Ping_Handler,
@@ -270,6 +624,21 @@ var FileTransferService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _FileTransferService_Download_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "Walk",
+			Handler:       _FileTransferService_Walk_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "List",
+			Handler:       _FileTransferService_List_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Glob",
+			Handler:       _FileTransferService_Glob_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "filetransfer.proto",
 }
@@ -0,0 +1,113 @@
+package grpcp
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/peer"
+)
+
+// ByteSize is a number of bytes with an optional suffix K, M or G (powers of 1024), like "20M".
+type ByteSize int64
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	s := strings.ToUpper(strings.TrimSpace(string(text)))
+	s = strings.TrimSuffix(s, "B")
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size: %s", text)
+	}
+	*b = ByteSize(n * float64(unit))
+	return nil
+}
+
+// newLimiter returns a token bucket limiter of bytesPerSec, or nil for no limit.
+func newLimiter(bytesPerSec int64) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), int(bytesPerSec))
+}
+
+// waitLimiters blocks until all the limiters allow n bytes to be transferred.
+// nil limiters are ignored.
+func waitLimiters(ctx context.Context, n int, limiters ...*rate.Limiter) error {
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+		// WaitN fails if n exceeds the burst size
+		for rest := n; rest > 0; {
+			k := min(rest, l.Burst())
+			if err := l.WaitN(ctx, k); err != nil {
+				return err
+			}
+			rest -= k
+		}
+	}
+	return nil
+}
+
+// serverLimiter limits the bandwidth of the server as a whole and per connection.
+type serverLimiter struct {
+	global  *rate.Limiter
+	perConn int64
+
+	mu    sync.Mutex
+	conns map[string]*connLimiter
+}
+
+type connLimiter struct {
+	limiter *rate.Limiter
+	streams int
+}
+
+func newServerLimiter(opt *ServerOption) *serverLimiter {
+	return &serverLimiter{
+		global:  newLimiter(opt.RateLimit),
+		perConn: opt.ConnRateLimit,
+		conns:   make(map[string]*connLimiter),
+	}
+}
+
+// acquire returns the limiters for the transfer of the client connection in ctx.
+// release must be called after the transfer to discard the limiter of the closed connection.
+func (l *serverLimiter) acquire(ctx context.Context) (limiters []*rate.Limiter, release func()) {
+	limiters = []*rate.Limiter{l.global}
+	p, ok := peer.FromContext(ctx)
+	if l.perConn <= 0 || !ok {
+		return limiters, func() {}
+	}
+	key := p.Addr.String()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.conns[key]
+	if !ok {
+		c = &connLimiter{limiter: newLimiter(l.perConn)}
+		l.conns[key] = c
+	}
+	c.streams++
+	return append(limiters, c.limiter), func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if c.streams--; c.streams == 0 {
+			delete(l.conns, key)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package grpcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+
+	pb "github.com/fujiwara/grpcp/proto"
+)
+
+type copySummary struct {
+	Files  int
+	Dirs   int
+	Bytes  int64
+	Errors []error
+}
+
+func (s *copySummary) log(msg string) error {
+	slog.Info(msg, "files", s.Files, "dirs", s.Dirs, "bytes", s.Bytes, "errors", len(s.Errors))
+	if len(s.Errors) > 0 {
+		return fmt.Errorf("failed to copy %d files: %w", len(s.Errors), errors.Join(s.Errors...))
+	}
+	return nil
+}
+
+func uploadDir(ctx context.Context, client pb.FileTransferServiceClient, remoteDir, localDir string, opt *ClientOption) error {
+	st, err := os.Stat(localDir)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if !st.IsDir() {
+		return retryTransfer(uploadFile)(ctx, client, remoteDir, localDir, opt)
+	}
+
+	// like cp -r, copy into remoteDir if it already exists
+	var res *pb.StatResponse
+	err = opt.Retry.do(ctx, func(error) (err error) {
+		res, err = client.Stat(ctx, &pb.StatRequest{Filename: remoteDir})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to stat remote file: %w", err)
+	}
+	if res.Exists && res.IsDir {
+		remoteDir = path.Join(remoteDir, filepath.Base(localDir))
+	}
+
+	var summary copySummary
+	err = filepath.WalkDir(localDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to walk directory: %w", err)
+		}
+		rel, err := filepath.Rel(localDir, p)
+		if err != nil {
+			return err
+		}
+		remoteFile := path.Join(remoteDir, filepath.ToSlash(rel))
+		switch {
+		case d.IsDir():
+			err := opt.Retry.do(ctx, func(error) error {
+				_, err := client.Mkdir(ctx, &pb.MkdirRequest{Filename: remoteFile, Parents: true})
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create remote directory %s: %w", remoteFile, err)
+			}
+			summary.Dirs++
+		case d.Type().IsRegular():
+			if err := retryTransfer(uploadFile)(ctx, client, remoteFile, p, opt); err != nil {
+				slog.Error("failed to upload", "local", p, "error", err)
+				summary.Errors = append(summary.Errors, fmt.Errorf("%s: %w", p, err))
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return fmt.Errorf("failed to stat file: %w", err)
+			}
+			summary.Files++
+			summary.Bytes += info.Size()
+		default:
+			slog.Warn("skipping non-regular file", "local", p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return summary.log("recursive copy completed")
+}
+
+func downloadDir(ctx context.Context, client pb.FileTransferServiceClient, remoteDir, localDir string, opt *ClientOption) error {
+	var res *pb.StatResponse
+	err := opt.Retry.do(ctx, func(error) (err error) {
+		res, err = client.Stat(ctx, &pb.StatRequest{Filename: remoteDir})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to stat remote file: %w", err)
+	}
+	if !res.IsDir {
+		return retryTransfer(downloadFile)(ctx, client, remoteDir, localDir, opt)
+	}
+
+	// like cp -r, copy into localDir if it already exists
+	if st, err := os.Stat(localDir); err == nil && st.IsDir() {
+		localDir = filepath.Join(localDir, path.Base(remoteDir))
+	}
+
+	var entries []*pb.WalkResponse
+	err = opt.Retry.do(ctx, func(error) (err error) {
+		entries, err = walkRemote(ctx, client, remoteDir)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var summary copySummary
+	for _, entry := range entries {
+		localFile := filepath.Join(localDir, filepath.FromSlash(entry.Filename))
+		if entry.IsDir {
+			if err := os.MkdirAll(localFile, 0755); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+			summary.Dirs++
+			continue
+		}
+		remoteFile := path.Join(remoteDir, entry.Filename)
+		if err := retryTransfer(downloadFile)(ctx, client, remoteFile, localFile, opt); err != nil {
+			slog.Error("failed to download", "remote", remoteFile, "error", err)
+			summary.Errors = append(summary.Errors, fmt.Errorf("%s: %w", remoteFile, err))
+			continue
+		}
+		summary.Files++
+		summary.Bytes += entry.Size
+	}
+	return summary.log("recursive copy completed")
+}
+
+// walkRemote returns the entries of the remote directory.
+func walkRemote(ctx context.Context, client pb.FileTransferServiceClient, remoteDir string) ([]*pb.WalkResponse, error) {
+	stream, err := client.Walk(ctx, &pb.WalkRequest{Filename: remoteDir})
+	if err != nil {
+		return nil, fmt.Errorf("failed to new walk stream: %w", err)
+	}
+	var entries []*pb.WalkResponse
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to receive response: %w", err)
+		}
+		if !filepath.IsLocal(filepath.FromSlash(res.Filename)) {
+			return nil, fmt.Errorf("invalid path received from server: %s", res.Filename)
+		}
+		entries = append(entries, res)
+	}
+}
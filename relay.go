@@ -0,0 +1,90 @@
+package grpcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+
+	pb "github.com/fujiwara/grpcp/proto"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// relay copies the remote file src on a host to the remote path dest on another host.
+// The content is downloaded from the source server and uploaded to the destination server
+// through a pipe, without being stored on the client.
+// The servers do not need the credentials for each other, because both connections are made by the client.
+func (c *Client) relay(ctx context.Context, src, dest string) error {
+	if c.Option.Recursive {
+		return status.Error(codes.InvalidArgument, "recursive copy between remote hosts is not supported")
+	}
+	srcClient, srcFile, closeSrc, err := c.dialRemote(src)
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+	destClient, destFile, closeDest, err := c.dialRemote(dest)
+	if err != nil {
+		return err
+	}
+	defer closeDest()
+	if hasGlobMeta(srcFile) {
+		return status.Errorf(codes.InvalidArgument, "glob pattern is not supported for copying between remote hosts: %s", src)
+	}
+
+	// like cp, copy into dest if it is a directory
+	if strings.HasSuffix(destFile, "/") {
+		destFile = path.Join(destFile, path.Base(srcFile))
+	} else {
+		var res *pb.StatResponse
+		err := c.Option.Retry.do(ctx, func(error) (err error) {
+			res, err = destClient.Stat(ctx, &pb.StatRequest{Filename: destFile})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to stat remote file: %w", err)
+		}
+		if res.Exists && res.IsDir {
+			destFile = path.Join(destFile, path.Base(srcFile))
+		}
+	}
+
+	slog.Info("starting relay", "src", src, "dest", destFile)
+	// nothing is committed on failure, so the whole file is relayed again on retry
+	return c.Option.Retry.do(ctx, func(error) error {
+		return relayFile(ctx, srcClient, srcFile, destClient, destFile, c.Option)
+	})
+}
+
+// relayFile pipes the download stream of srcFile into the upload stream of destFile.
+func relayFile(ctx context.Context, srcClient pb.FileTransferServiceClient, srcFile string, destClient pb.FileTransferServiceClient, destFile string, opt *ClientOption) error {
+	// show the progress of the download, whose size is known
+	uopt := *opt
+	uopt.Quiet = true
+
+	pr, pw := io.Pipe()
+	var metadata *pb.FileMetadata
+	eg, egctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		// the size and the checksum are verified before EOF, so the upload is not committed on mismatch
+		var err error
+		metadata, err = downloadTo(egctx, srcClient, srcFile, pw, opt)
+		pw.CloseWithError(err)
+		return err
+	})
+	eg.Go(func() error {
+		// the metadata is set before the pipe is closed, so it is ready at EOF
+		err := uploadReader(egctx, destClient, destFile, pr, func() *pb.FileMetadata { return metadata }, &uopt)
+		pr.CloseWithError(err)
+		return err
+	})
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	slog.Info("client relay completed", "src", srcFile, "dest", destFile)
+	return nil
+}
@@ -0,0 +1,111 @@
+package grpcp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// certReloader serves the certificate loaded from the files, and reloads it when the files are replaced.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load key pair: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.modTime = modTime
+	slog.Info("server certificate", "cert", r.certFile, "fingerprint", certFingerprint(cert.Certificate[0]))
+	return nil
+}
+
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		st, err := os.Stat(name)
+		if err != nil {
+			return latest, fmt.Errorf("failed to stat certificate: %w", err)
+		}
+		if st.ModTime().After(latest) {
+			latest = st.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) modified() bool {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		// the files may be being replaced
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !modTime.Equal(r.modTime)
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// watch reloads the certificate on SIGHUP, or when the files are modified.
+// If interval is zero, the files are not watched.
+func (r *certReloader) watch(ctx context.Context, interval time.Duration) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigCh:
+			slog.Info("reloading certificate by SIGHUP")
+		case <-tick:
+			if !r.modified() {
+				continue
+			}
+			slog.Info("reloading certificate by modification")
+		}
+		if err := r.reload(); err != nil {
+			slog.Error("failed to reload certificate. keep using the current one", "error", err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package grpcp
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	pb "github.com/fujiwara/grpcp/proto"
+	"google.golang.org/grpc/codes"
+)
+
+// RetryPolicy is the policy to retry requests failed by temporary errors,
+// such as Unavailable on network failures.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the first one. 0 or 1 disables retries.
+	MaxAttempts int `json:"max_attempts"`
+	// InitialBackoff is the time to wait before the first retry.
+	InitialBackoff time.Duration `json:"initial_backoff"`
+	// MaxBackoff is the upper limit of the time to wait. 0 means no limit.
+	MaxBackoff time.Duration `json:"max_backoff"`
+	// Multiplier is the factor to increase the backoff on each retry (default: 2).
+	Multiplier float64 `json:"multiplier"`
+	// Jitter is the factor to randomize the backoff, from 0 (no jitter) to 1.
+	Jitter float64 `json:"jitter"`
+}
+
+func (p *RetryPolicy) enabled() bool {
+	return p.MaxAttempts > 1
+}
+
+// backoff returns the time to wait before the next attempt of the attempt-th failure.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	d := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(rand.Float64()*2-1)
+	}
+	return time.Duration(d)
+}
+
+// do calls fn until it succeeds, fails with a non-retryable error, or the attempts are exhausted.
+// fn receives the error of the previous attempt, or nil on the first attempt.
+func (p *RetryPolicy) do(ctx context.Context, fn func(prev error) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn(err)
+		if err == nil || attempt >= p.MaxAttempts || !isRetryable(errorCode(err)) {
+			return err
+		}
+		d := p.backoff(attempt)
+		slog.Warn("retrying", "attempt", attempt+1, "max_attempts", p.MaxAttempts, "backoff", d, "error", err)
+		tm := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			tm.Stop()
+			return err
+		case <-tm.C:
+		}
+	}
+}
+
+// retryTransfer returns the transfer function retried by opt.Retry.
+// The retries resume the transfer from the partially transferred file,
+// except for the failures by corrupted content which are transferred from the beginning.
+func retryTransfer(transfer transferFunc) transferFunc {
+	return func(ctx context.Context, client pb.FileTransferServiceClient, remoteFile, localFile string, opt *ClientOption) error {
+		if !opt.Retry.enabled() {
+			return transfer(ctx, client, remoteFile, localFile, opt)
+		}
+		return opt.Retry.do(ctx, func(prev error) error {
+			if prev == nil {
+				return transfer(ctx, client, remoteFile, localFile, opt)
+			}
+			o := *opt
+			o.Resume = errorCode(prev) != codes.DataLoss
+			return transfer(ctx, client, remoteFile, localFile, &o)
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package grpcp
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// resolvePath resolves the filename requested by a client to a path on the server.
+// If root is empty, the filename is used as is.
+// Otherwise the filename is resolved relative to root, and paths escaping root by ".." or symlinks are rejected.
+func resolvePath(root, filename string) (string, error) {
+	if root == "" {
+		return filename, nil
+	}
+	name := strings.TrimLeft(filepath.ToSlash(filename), "/")
+	if name == "" {
+		name = "."
+	}
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", status.Errorf(codes.PermissionDenied, "path %s is outside of the root directory", filename)
+	}
+	// the resolved path is absolute, so that hideRoot finds the root in the error messages
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to resolve root directory: %s", err)
+	}
+	realRoot, err := filepath.EvalSymlinks(absRoot)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to resolve root directory: %s", err)
+	}
+	p := filepath.Join(realRoot, filepath.FromSlash(name))
+
+	// resolve symlinks of the longest existing prefix of the path
+	existing, rest := p, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			existing = resolved
+			break
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", status.Errorf(codes.PermissionDenied, "failed to resolve path %s: %s", filename, err)
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = filepath.Dir(existing)
+	}
+	if !isWithin(realRoot, existing) {
+		return "", status.Errorf(codes.PermissionDenied, "path %s is outside of the root directory", filename)
+	}
+	return filepath.Join(existing, rest), nil
+}
+
+// resolveLinkPath is like resolvePath, but does not follow the symlink of the last path component,
+// so that the link itself is removed or renamed instead of its target.
+func resolveLinkPath(root, filename string) (string, error) {
+	if root == "" {
+		return filename, nil
+	}
+	name := strings.TrimLeft(filepath.ToSlash(filename), "/")
+	if name != "" && !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", status.Errorf(codes.PermissionDenied, "path %s is outside of the root directory", filename)
+	}
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return resolvePath(root, name)
+	}
+	dir, err := resolvePath(root, path.Dir(name))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, path.Base(name)), nil
+}
+
+// resolvePattern resolves the glob pattern requested by a client to a pattern on the server.
+// The leading directories without special characters are resolved by resolvePath,
+// and escaped so that the special characters in the root directory itself are matched literally.
+func resolvePattern(root, pattern string) (string, error) {
+	if root == "" {
+		return pattern, nil
+	}
+	name := strings.TrimLeft(filepath.ToSlash(pattern), "/")
+	if name != "" && !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", status.Errorf(codes.PermissionDenied, "path %s is outside of the root directory", pattern)
+	}
+	elems := strings.Split(path.Clean("/"+name), "/")
+	i := slices.IndexFunc(elems, hasGlobMeta)
+	if i < 0 {
+		i = len(elems)
+	}
+	dir, err := resolvePath(root, path.Join(elems[:i]...))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{escapeGlob(dir)}, elems[i:]...)...), nil
+}
+
+// escapeGlob escapes the special characters of filepath.Match in p.
+// The escape by backslash is not supported on Windows, where it is the path separator.
+func escapeGlob(p string) string {
+	if runtime.GOOS == "windows" {
+		return p
+	}
+	var b strings.Builder
+	for _, r := range p {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func isWithin(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+	return rel == "." || filepath.IsLocal(rel)
+}
+
+// checkRoot validates that root is an existing directory.
+func checkRoot(root string) error {
+	if root == "" {
+		return nil
+	}
+	st, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !st.IsDir() {
+		return &fs.PathError{Op: "root", Path: root, Err: errors.New("not a directory")}
+	}
+	return nil
+}
@@ -3,20 +3,32 @@ package grpcp
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"net"
 	"os"
-	"sync"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 
 	pb "github.com/fujiwara/grpcp/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 type server struct {
 	pb.UnimplementedFileTransferServiceServer
+	opt      *ServerOption
+	shutdown context.CancelFunc
+	limiter  *serverLimiter
 }
 
 var (
@@ -25,7 +37,7 @@ var (
 
 func (s *server) Ping(ctx context.Context, req *pb.PingRequest) (*pb.PingResponse, error) {
 	slog.Info("ping", "message", req.Message)
-	return &pb.PingResponse{Message: "pong"}, nil
+	return &pb.PingResponse{Message: "pong", Compressions: supportedCompressions}, nil
 }
 
 func newUploadResponse(msg string) *pb.FileUploadResponse {
@@ -34,54 +46,216 @@ func newUploadResponse(msg string) *pb.FileUploadResponse {
 
 func (s *server) Upload(stream pb.FileTransferService_UploadServer) error {
 	if err := s.upload(stream); err != nil {
-		slog.Error(err.Error())
-		return err
+		return s.statusError(err)
 	}
 	return nil
 }
 
 func (s *server) upload(stream pb.FileTransferService_UploadServer) error {
-	var once sync.Once
-	var f *os.File
-	var totalBytes, expectedSize int64
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return status.Error(codes.InvalidArgument, "no upload request received")
+	} else if err != nil {
+		return fmt.Errorf("failed to receive file: %w", err)
+	}
+	slog.Info("server accepting upload request", "filename", req.Filename, "bytes", req.Size, "offset", req.Offset, "length", req.Length, "client", authName(stream.Context()))
+	filename, err := resolvePath(s.opt.Root, req.Filename)
+	if err != nil {
+		return err
+	}
+	// the temporary files are created next to the target, which is outside of the root for the root itself
+	if err := s.checkNotRoot(filename); err != nil {
+		return err
+	}
+	h, err := newHash(req.ChecksumAlgorithm)
+	if err != nil {
+		return err
+	}
+	// f is nil for a range of parallel upload, which is committed by CommitUpload
+	var f *atomicFile
+	var fw io.Writer
+	expectedSize := req.Size
+	streaming := req.Size == unknownSize
+	if streaming && (req.Offset != 0 || req.Length != 0 || req.Resume) {
+		return status.Error(codes.InvalidArgument, "content of unknown size cannot be resumed or uploaded in ranges")
+	}
+	if req.Length != 0 {
+		// not req.Offset+req.Length, which may overflow
+		if req.Offset < 0 || req.Offset > req.Size || req.Length < 0 || req.Length > req.Size-req.Offset {
+			return status.Errorf(codes.OutOfRange, "range %d-%d is out of range of the file size %d", req.Offset, req.Offset+req.Length, req.Size)
+		}
+		rf, err := openRangeFile(filename)
+		if err != nil {
+			return err
+		}
+		defer rf.Close()
+		fw = io.NewOffsetWriter(rf, req.Offset)
+		expectedSize = req.Offset + req.Length
+	} else {
+		// the ranges of a parallel upload never committed are stale
+		if err := os.Remove(rangeFilename(filename)); err == nil {
+			slog.Info("removed stale ranges of parallel upload", "filename", req.Filename)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove file: %w", err)
+		}
+		f, err = createAtomicFile(filename, req.Offset, req.Resume)
+		if err != nil {
+			return err
+		}
+		defer f.Abort()
+		if err := hashPrefix(h, f, req.Offset); err != nil {
+			return err
+		}
+		fw = f
+	}
+
+	var w io.Writer = fw
+	if h != nil {
+		w = io.MultiWriter(fw, h)
+	}
+	d := &decompressor{}
+	defer d.Close()
+	limiters, release := s.limiter.acquire(stream.Context())
+	defer release()
+	var checksum []byte
+	metadata := req.Metadata
+	totalBytes := req.Offset
 	for {
-		req, err := stream.Recv()
+		if err := waitLimiters(stream.Context(), len(req.Content), limiters...); err != nil {
+			return err
+		}
+		content, err := d.decompress(req.Content, req.Compression)
+		if err != nil {
+			return err
+		}
+		if n, err := w.Write(content); err != nil {
+			return fmt.Errorf("failed to write file: %w", err)
+		} else {
+			totalBytes += int64(n)
+		}
+		if req.Checksum != nil {
+			checksum = req.Checksum
+		}
+		if req.Metadata != nil {
+			metadata = req.Metadata
+		}
+		if streaming && req.Size >= 0 {
+			expectedSize = req.Size
+		}
+		req, err = stream.Recv()
 		if err == io.EOF {
 			slog.Info("server upload completed", "bytes", totalBytes)
+			if expectedSize < 0 {
+				return status.Errorf(codes.DataLoss, "file size was not received after %d bytes", totalBytes)
+			}
 			if totalBytes != expectedSize {
-				return fmt.Errorf("file size mismatch: expected %d bytes, got %d bytes", expectedSize, totalBytes)
+				return status.Errorf(codes.DataLoss, "file size mismatch: expected %d bytes, got %d bytes", expectedSize, totalBytes)
+			}
+			if err := verifyChecksum(h, checksum); err != nil {
+				return err
+			}
+			if f == nil {
+				return stream.SendAndClose(newUploadResponse("Range received successfully"))
+			}
+			if err := applyMetadata(f.File, metadata, s.opt.PreserveOwner); err != nil {
+				return err
+			}
+			if err := f.Commit(); err != nil {
+				return err
 			}
 			return stream.SendAndClose(newUploadResponse("Upload received successfully"))
 		} else if err != nil {
 			return fmt.Errorf("failed to receive file: %w", err)
 		}
-		once.Do(func() {
-			slog.Info("server accepting upload request", "filename", req.Filename, "bytes", req.Size)
-			f, err = os.OpenFile(req.Filename, os.O_WRONLY|os.O_CREATE, 0644)
-			expectedSize = req.Size
-		})
-		if err != nil || f == nil {
-			return fmt.Errorf("failed to open file: %w", err)
+	}
+}
+
+func (s *server) CommitUpload(ctx context.Context, req *pb.CommitUploadRequest) (*pb.CommitUploadResponse, error) {
+	if err := s.commitUpload(req); err != nil {
+		return nil, s.statusError(err)
+	}
+	return &pb.CommitUploadResponse{}, nil
+}
+
+func (s *server) commitUpload(req *pb.CommitUploadRequest) error {
+	filename, err := resolvePath(s.opt.Root, req.Filename)
+	if err != nil {
+		return err
+	}
+	if err := s.checkNotRoot(filename); err != nil {
+		return err
+	}
+	if req.Abort {
+		slog.Info("server aborting parallel upload", "filename", req.Filename)
+		if err := os.Remove(rangeFilename(filename)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove file: %w", err)
 		}
-		if n, err := f.Write(req.Content); err != nil {
-			return fmt.Errorf("failed to write file: %w", err)
-		} else {
-			totalBytes += int64(n)
+		return nil
+	}
+	f, err := openNoFollow(rangeFilename(filename), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	af := &atomicFile{File: f, path: filename, keep: true}
+	defer af.Abort()
+	st, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if st.Size() < req.Size {
+		return status.Errorf(codes.DataLoss, "file size mismatch: expected %d bytes, got %d bytes", req.Size, st.Size())
+	} else if st.Size() > req.Size {
+		// left by a previous upload of a larger file
+		if err := f.Truncate(req.Size); err != nil {
+			return fmt.Errorf("failed to truncate file: %w", err)
 		}
 	}
+	// each range is verified by its checksum, but the ranges may be mixed with the ones of another upload
+	h, err := newHash(req.ChecksumAlgorithm)
+	if err != nil {
+		return err
+	}
+	if err := hashPrefix(h, f, req.Size); err != nil {
+		return err
+	}
+	if err := verifyChecksum(h, req.Checksum); err != nil {
+		// the content is broken, so it is not worth keeping
+		af.keep = false
+		return err
+	}
+	if err := applyMetadata(f, req.Metadata, s.opt.PreserveOwner); err != nil {
+		return err
+	}
+	if err := af.Commit(); err != nil {
+		return err
+	}
+	slog.Info("server parallel upload committed", "filename", req.Filename, "bytes", req.Size)
+	return nil
 }
 
 func (s *server) Download(req *pb.FileDownloadRequest, stream pb.FileTransferService_DownloadServer) error {
 	if err := s.download(req, stream); err != nil {
-		slog.Error(err.Error())
-		return err
+		return s.statusError(err)
 	}
 	return nil
 }
 
 func (s *server) download(req *pb.FileDownloadRequest, stream pb.FileTransferService_DownloadServer) error {
-	slog.Info("server accepting download request", "filename", req.Filename)
-	f, err := os.Open(req.Filename)
+	slog.Info("server accepting download request", "filename", req.Filename, "offset", req.Offset, "length", req.Length, "client", authName(stream.Context()))
+	filename, err := resolvePath(s.opt.Root, req.Filename)
+	if err != nil {
+		return err
+	}
+	h, err := newHash(req.ChecksumAlgorithm)
+	if err != nil {
+		return err
+	}
+	c, err := newCompressor(req.Compression, supportedCompressions)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	f, err := os.Open(filename)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
 	}
@@ -91,78 +265,561 @@ func (s *server) download(req *pb.FileDownloadRequest, stream pb.FileTransferSer
 		return fmt.Errorf("failed to stat file: %w", err)
 	}
 	expectedBytes := st.Size()
-	totalBytes := int64(0)
+	var metadata *pb.FileMetadata
+	if req.Preserve {
+		metadata = newMetadata(st)
+	}
+	if req.Offset < 0 || req.Offset > expectedBytes {
+		return status.Errorf(codes.OutOfRange, "offset %d is out of range of the file size %d", req.Offset, expectedBytes)
+	}
+	// the end of the content to be sent. the checksum of a range does not cover the prefix
+	end := expectedBytes
+	if req.Length != 0 {
+		if req.Length < 0 || req.Length > expectedBytes-req.Offset {
+			return status.Errorf(codes.OutOfRange, "range %d-%d is out of range of the file size %d", req.Offset, req.Offset+req.Length, expectedBytes)
+		}
+		end = req.Offset + req.Length
+	} else if err := hashPrefix(h, f, req.Offset); err != nil {
+		return err
+	}
+	if _, err := f.Seek(req.Offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek file: %w", err)
+	}
+	var r io.Reader = io.LimitReader(f, end-req.Offset)
+	if h != nil {
+		r = io.TeeReader(r, h)
+	}
+	limiters, release := s.limiter.acquire(stream.Context())
+	defer release()
+	totalBytes := req.Offset
 	buf := make([]byte, StreamBufferSize)
+	sent := false
 	for {
-		n, err := f.Read(buf)
+		n, err := r.Read(buf)
 		if err == io.EOF {
 			slog.Info("server download completed", "bytes", totalBytes)
-			if totalBytes != expectedBytes {
-				return fmt.Errorf("file size mismatch: expected %d bytes, got %d bytes", expectedBytes, totalBytes)
+			if totalBytes != end {
+				return status.Errorf(codes.DataLoss, "file size mismatch: expected %d bytes, got %d bytes", end, totalBytes)
+			}
+			if sent && h == nil {
+				return nil
+			}
+			// send the last message with the checksum, or notify the file size if nothing was sent
+			res := &pb.FileDownloadResponse{
+				Filename: req.Filename,
+				Size:     expectedBytes,
+				Checksum: sum(h),
+			}
+			if !sent {
+				res.Metadata = metadata
+			}
+			if err := stream.Send(res); err != nil {
+				return fmt.Errorf("failed to send file: %w", err)
 			}
 			return nil
 		} else if err != nil {
 			return fmt.Errorf("failed to read file: %w", err)
 		}
-		if err := stream.Send(&pb.FileDownloadResponse{
-			Filename: req.Filename,
-			Content:  buf[:n],
-			Size:     expectedBytes,
-		}); err != nil {
+		content, codec, err := c.compress(buf[:n])
+		if err != nil {
+			return err
+		}
+		if err := waitLimiters(stream.Context(), len(content), limiters...); err != nil {
+			return err
+		}
+		res := &pb.FileDownloadResponse{
+			Filename:    req.Filename,
+			Content:     content,
+			Size:        expectedBytes,
+			Compression: codec,
+		}
+		if !sent {
+			res.Metadata = metadata
+		}
+		sent = true
+		if err := stream.Send(res); err != nil {
 			return fmt.Errorf("failed to send file: %w", err)
 		}
 		totalBytes += int64(n)
 	}
 }
 
-func (s *server) Shutdown(ctx context.Context, req *pb.ShutdownRequest) (*pb.ShutdownResponse, error) {
-	slog.Info("server shutdown requested")
-	go func() {
-		tm := time.NewTimer(time.Second)
-		<-tm.C
-		slog.Info("server shutdown completed")
-		os.Exit(0)
-	}()
-	return &pb.ShutdownResponse{}, nil
+func (s *server) Stat(ctx context.Context, req *pb.StatRequest) (*pb.StatResponse, error) {
+	filename, err := resolvePath(s.opt.Root, req.Filename)
+	if err != nil {
+		return nil, s.statusError(err)
+	}
+	stat := os.Stat
+	if req.Partial {
+		// the partial file of the root is outside of the root
+		if err := s.checkNotRoot(filename); err != nil {
+			return nil, s.statusError(err)
+		}
+		// the partial file is not resolved by resolvePath, so a symbolic link is not followed
+		filename = partialFilename(filename)
+		stat = os.Lstat
+	}
+	st, err := stat(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &pb.StatResponse{Exists: false}, nil
+	} else if err != nil {
+		return nil, s.statusError(fmt.Errorf("failed to stat file: %w", err))
+	}
+	if req.Partial && !st.Mode().IsRegular() {
+		return nil, s.statusError(status.Errorf(codes.PermissionDenied, "%s is not a regular file", filename))
+	}
+	return &pb.StatResponse{Exists: true, Size: st.Size(), IsDir: st.IsDir()}, nil
 }
 
-func newListener(addr string, opt *ServerOption) (net.Listener, error) {
-	lis, err := net.Listen("tcp", addr)
+func (s *server) Mkdir(ctx context.Context, req *pb.MkdirRequest) (*pb.MkdirResponse, error) {
+	slog.Info("server accepting mkdir request", "filename", req.Filename, "parents", req.Parents, "client", authName(ctx))
+	filename, err := resolvePath(s.opt.Root, req.Filename)
+	if err != nil {
+		return nil, s.statusError(err)
+	}
+	if req.Parents {
+		err = os.MkdirAll(filename, 0755)
+	} else {
+		err = os.Mkdir(filename, 0755)
+	}
+	if err != nil {
+		return nil, s.statusError(fmt.Errorf("failed to create directory: %w", err))
+	}
+	return &pb.MkdirResponse{}, nil
+}
+
+func (s *server) Walk(req *pb.WalkRequest, stream pb.FileTransferService_WalkServer) error {
+	if err := s.walk(req, stream); err != nil {
+		return s.statusError(err)
+	}
+	return nil
+}
+
+func (s *server) walk(req *pb.WalkRequest, stream pb.FileTransferService_WalkServer) error {
+	slog.Info("server accepting walk request", "filename", req.Filename, "client", authName(stream.Context()))
+	root, err := resolvePath(s.opt.Root, req.Filename)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to walk directory: %w", err)
+		}
+		// symbolic links and special files are not followed
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat file: %w", err)
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		return stream.Send(&pb.WalkResponse{
+			Filename: filepath.ToSlash(rel),
+			IsDir:    d.IsDir(),
+			Size:     info.Size(),
+		})
+	})
+}
+
+func (s *server) List(req *pb.ListRequest, stream pb.FileTransferService_ListServer) error {
+	if err := s.list(req, stream); err != nil {
+		return s.statusError(err)
+	}
+	return nil
+}
+
+func (s *server) list(req *pb.ListRequest, stream pb.FileTransferService_ListServer) error {
+	slog.Info("server accepting list request", "filename", req.Filename, "client", authName(stream.Context()))
+	filename, err := resolvePath(s.opt.Root, req.Filename)
+	if err != nil {
+		return err
+	}
+	st, err := os.Stat(filename)
 	if err != nil {
-		return nil, fmt.Errorf("failed to listen: %w", err)
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if !st.IsDir() {
+		return stream.Send(newListResponse(st))
 	}
+	entries, err := os.ReadDir(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// removed after reading the directory
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to stat file: %w", err)
+		}
+		if err := stream.Send(newListResponse(info)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *server) Glob(req *pb.GlobRequest, stream pb.FileTransferService_GlobServer) error {
+	if err := s.glob(req, stream); err != nil {
+		return s.statusError(err)
+	}
+	return nil
+}
+
+func (s *server) glob(req *pb.GlobRequest, stream pb.FileTransferService_GlobServer) error {
+	slog.Info("server accepting glob request", "pattern", req.Pattern, "client", authName(stream.Context()))
+	var matches []string
+	// an existing file is matched literally, even if its name contains the special characters
+	literal, err := resolvePath(s.opt.Root, req.Pattern)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(literal); err == nil {
+		matches = []string{literal}
+	} else {
+		pattern, err := resolvePattern(s.opt.Root, req.Pattern)
+		if err != nil {
+			return err
+		}
+		if matches, err = filepath.Glob(pattern); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid pattern %s: %s", req.Pattern, err)
+		}
+	}
+	var root string
+	if s.opt.Root != "" {
+		if root, err = resolvePath(s.opt.Root, "/"); err != nil {
+			return err
+		}
+	}
+	for _, match := range matches {
+		filename := match
+		if root != "" {
+			// the path seen by the client is relative to the root directory
+			rel, err := filepath.Rel(root, match)
+			if err != nil {
+				return err
+			}
+			filename = "/" + filepath.ToSlash(rel)
+		}
+		// symbolic links may point to outside of the root directory
+		resolved, err := resolvePath(s.opt.Root, filename)
+		if err != nil {
+			continue
+		}
+		st, err := os.Stat(resolved)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to stat file: %w", err)
+		}
+		if !st.IsDir() && !st.Mode().IsRegular() {
+			continue
+		}
+		if err := stream.Send(&pb.GlobResponse{
+			Filename: filename,
+			IsDir:    st.IsDir(),
+			Size:     st.Size(),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newListResponse(st fs.FileInfo) *pb.ListResponse {
+	return &pb.ListResponse{
+		Filename: st.Name(),
+		IsDir:    st.IsDir(),
+		Size:     st.Size(),
+		Mode:     uint32(st.Mode()),
+		Mtime:    st.ModTime().UnixNano(),
+	}
+}
+
+func (s *server) Remove(ctx context.Context, req *pb.RemoveRequest) (*pb.RemoveResponse, error) {
+	slog.Info("server accepting remove request", "filename", req.Filename, "recursive", req.Recursive, "client", authName(ctx))
+	filename, err := resolveLinkPath(s.opt.Root, req.Filename)
+	if err != nil {
+		return nil, s.statusError(err)
+	}
+	if err := s.checkNotRoot(filename); err != nil {
+		return nil, s.statusError(err)
+	}
+	// os.RemoveAll succeeds for a missing file
+	st, err := os.Lstat(filename)
+	if err != nil {
+		return nil, s.statusError(fmt.Errorf("failed to stat file: %w", err))
+	}
+	// like rm, os.Remove would remove an empty directory
+	if st.IsDir() && !req.Recursive {
+		return nil, s.statusError(fmt.Errorf("failed to remove file: %w", &fs.PathError{Op: "remove", Path: filename, Err: syscall.EISDIR}))
+	}
+	if req.Recursive {
+		err = os.RemoveAll(filename)
+	} else {
+		err = os.Remove(filename)
+	}
+	if err != nil {
+		return nil, s.statusError(fmt.Errorf("failed to remove file: %w", err))
+	}
+	return &pb.RemoveResponse{}, nil
+}
+
+func (s *server) Rename(ctx context.Context, req *pb.RenameRequest) (*pb.RenameResponse, error) {
+	slog.Info("server accepting rename request", "src", req.Src, "dest", req.Dest, "client", authName(ctx))
+	src, err := resolveLinkPath(s.opt.Root, req.Src)
+	if err != nil {
+		return nil, s.statusError(err)
+	}
+	if err := s.checkNotRoot(src); err != nil {
+		return nil, s.statusError(err)
+	}
+	dest, err := resolveLinkPath(s.opt.Root, req.Dest)
+	if err != nil {
+		return nil, s.statusError(err)
+	}
+	// like mv, move into dest if it is an existing directory, or a symlink to a directory
+	if st, err := os.Stat(dest); err == nil && st.IsDir() {
+		dir, err := resolvePath(s.opt.Root, req.Dest)
+		if err != nil {
+			return nil, s.statusError(err)
+		}
+		dest = filepath.Join(dir, filepath.Base(src))
+	}
+	if err := os.Rename(src, dest); err != nil {
+		return nil, s.statusError(fmt.Errorf("failed to rename file: %w", err))
+	}
+	return &pb.RenameResponse{}, nil
+}
+
+// statusError logs err with the full paths, and converts it into a gRPC status error for the client.
+// The paths on the server are shown to the client relative to the root directory.
+func (s *server) statusError(err error) error {
+	slog.Error(err.Error())
+	if _, ok := err.(interface{ GRPCStatus() *status.Status }); ok {
+		p := status.Convert(err).Proto()
+		p.Message = s.hideRoot(p.Message)
+		return status.FromProto(p).Err()
+	}
+	return statusError(&rootHiddenError{err: err, msg: s.hideRoot(err.Error())})
+}
+
+// hideRoot replaces the root directory in msg with "/".
+// Only the absolute paths of the root are replaced, because a relative one like "." may appear anywhere.
+func (s *server) hideRoot(msg string) string {
+	if s.opt.Root == "" {
+		return msg
+	}
+	abs, err := filepath.Abs(s.opt.Root)
+	if err != nil {
+		return msg
+	}
+	roots := []string{abs}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		roots = append(roots, real)
+	}
+	// replace the longer one first, which may contain the others
+	slices.SortFunc(roots, func(a, b string) int { return len(b) - len(a) })
+	for _, root := range roots {
+		if root == string(filepath.Separator) {
+			continue
+		}
+		msg = replacePathPrefix(msg, root, "/")
+	}
+	return msg
+}
+
+// replacePathPrefix replaces prefix in msg with repl where it is a whole path or its leading directory.
+func replacePathPrefix(msg, prefix, repl string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(msg, prefix)
+		if i < 0 {
+			break
+		}
+		end := i + len(prefix)
+		if (i > 0 && !isPathBoundary(msg[i-1])) || (end < len(msg) && msg[end] != filepath.Separator && !isPathBoundary(msg[end])) {
+			b.WriteString(msg[:end])
+			msg = msg[end:]
+			continue
+		}
+		b.WriteString(msg[:i])
+		b.WriteString(repl)
+		if end < len(msg) && msg[end] == filepath.Separator {
+			end++
+		}
+		msg = msg[end:]
+	}
+	b.WriteString(msg)
+	return b.String()
+}
+
+// isPathBoundary reports whether c separates a path from the other text in an error message.
+func isPathBoundary(c byte) bool {
+	return strings.IndexByte(" \t\n\"'`:,;()[]<>=", c) >= 0
+}
+
+// rootHiddenError is err whose message does not contain the root directory.
+type rootHiddenError struct {
+	err error
+	msg string
+}
+
+func (e *rootHiddenError) Error() string { return e.msg }
+func (e *rootHiddenError) Unwrap() error { return e.err }
+
+// checkNotRoot rejects the root directory of the server as the target of removing, renaming or writing.
+func (s *server) checkNotRoot(filename string) error {
+	root, err := resolvePath(s.opt.Root, "/")
+	if err != nil {
+		return err
+	}
+	if filepath.Clean(filename) == filepath.Clean(root) {
+		return status.Error(codes.PermissionDenied, "the root directory cannot be removed, renamed or overwritten")
+	}
+	return nil
+}
+
+func (s *server) Shutdown(ctx context.Context, req *pb.ShutdownRequest) (*pb.ShutdownResponse, error) {
+	name := authName(ctx)
+	if !s.opt.AllowRemoteShutdown {
+		slog.Warn("server shutdown rejected: remote shutdown is disabled", "client", name)
+		return nil, status.Error(codes.PermissionDenied, "remote shutdown is disabled")
+	}
+	if name == "" || !slices.Contains(s.opt.AdminNames, name) {
+		slog.Warn("server shutdown rejected: not an admin", "client", name)
+		return nil, status.Error(codes.PermissionDenied, "shutdown is allowed only for admins")
+	}
+	slog.Info("server shutdown requested", "client", name)
+	s.shutdown()
+	return &pb.ShutdownResponse{}, nil
+}
+
+func newServerCredentials(ctx context.Context, opt *ServerOption) (credentials.TransportCredentials, error) {
 	if !opt.TLS {
 		slog.Warn("running server without TLS")
-		return lis, nil
+		return insecure.NewCredentials(), nil
 	}
 
 	var tlsConfig *tls.Config
+	var err error
 	if opt.CertFile == "" || opt.KeyFile == "" {
 		slog.Info("generating self-signed certificate")
-		tlsConfig, err = genSelfSignedTLS()
+		tlsConfig, err = genSelfSignedTLS(opt.CertDir, certHosts(opt))
 		if err != nil {
 			return nil, fmt.Errorf("failed to generate tls config: %w", err)
 		}
+		slog.Info("server certificate", "fingerprint", certFingerprint(tlsConfig.Certificates[0].Certificate[0]))
 	} else {
 		slog.Info("loading certificate", "cert", opt.CertFile, "key", opt.KeyFile)
-		tlsConfig, err = genTLS(opt.CertFile, opt.KeyFile)
+		reloader, err := newCertReloader(opt.CertFile, opt.KeyFile)
 		if err != nil {
 			return nil, fmt.Errorf("failed to generate tls config: %w", err)
 		}
+		go reloader.watch(ctx, opt.CertReloadInterval)
+		tlsConfig = &tls.Config{GetCertificate: reloader.GetCertificate}
+	}
+	if opt.ClientCAFile != "" {
+		slog.Info("requiring client certificates", "client_ca", opt.ClientCAFile)
+		pool, err := loadCertPool(opt.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
 	}
-	return tls.NewListener(lis, tlsConfig), nil
+	return credentials.NewTLS(tlsConfig), nil
 }
 
+// stopServer stops the server gracefully, waiting for the active RPCs up to the timeout.
+// If the timeout is exceeded, the remaining RPCs are cancelled.
+// A timeout of 0 waits for the active RPCs indefinitely.
+func stopServer(s *grpc.Server, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	if timeout <= 0 {
+		<-done
+		return
+	}
+	tm := time.NewTimer(timeout)
+	defer tm.Stop()
+	select {
+	case <-done:
+	case <-tm.C:
+		slog.Warn("server shutdown timed out, cancelling active requests", "timeout", timeout)
+		s.Stop()
+		<-done
+	}
+}
+
+// RunServer runs the server until ctx is cancelled or the server is shut down by the Shutdown RPC.
+// On shutdown, it waits for the active transfers up to opt.ShutdownTimeout.
 func RunServer(ctx context.Context, opt *ServerOption) error {
-	s := grpc.NewServer()
+	if err := checkRoot(opt.Root); err != nil {
+		return fmt.Errorf("invalid root directory: %w", err)
+	}
+	if opt.AllowRemoteShutdown && len(opt.AdminNames) == 0 {
+		return fmt.Errorf("remote shutdown requires admin names to be specified")
+	}
+	if err := checkAdminNames(opt.AdminNames); err != nil {
+		return err
+	}
+	if opt.ClientCAFile != "" && !opt.TLS {
+		return fmt.Errorf("client certificates require TLS, but TLS is disabled")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	creds, err := newServerCredentials(ctx, opt)
+	if err != nil {
+		return err
+	}
+	serverOpts := []grpc.ServerOption{grpc.Creds(creds)}
+	if opt.TokenFile != "" {
+		tokens, err := LoadTokens(opt.TokenFile)
+		if err != nil {
+			return err
+		}
+		slog.Info("client authentication enabled", "tokens", len(tokens))
+		auth := &authenticator{tokens: tokens}
+		serverOpts = append(serverOpts,
+			grpc.UnaryInterceptor(auth.unaryInterceptor),
+			grpc.StreamInterceptor(auth.streamInterceptor),
+		)
+	} else {
+		slog.Warn("running server without client authentication")
+	}
+	s := grpc.NewServer(serverOpts...)
 	addr := fmt.Sprintf("%s:%d", opt.Listen, opt.Port)
-	lis, err := newListener(addr, opt)
+	lis, err := net.Listen("tcp", addr)
 	if err != nil {
-		return fmt.Errorf("failed to create listener: %w", err)
+		return fmt.Errorf("failed to listen: %w", err)
 	}
-	slog.Info("starting server", "addr", addr, "tls", opt.TLS)
-	pb.RegisterFileTransferServiceServer(s, &server{})
-	if err := s.Serve(lis); err != nil {
+	slog.Info("starting server", "addr", addr, "tls", opt.TLS, "root", opt.Root, "rate_limit", opt.RateLimit, "conn_rate_limit", opt.ConnRateLimit)
+	if opt.AllowRemoteShutdown {
+		slog.Info("remote shutdown enabled", "admins", opt.AdminNames)
+	}
+	pb.RegisterFileTransferServiceServer(s, &server{opt: opt, shutdown: cancel, limiter: newServerLimiter(opt)})
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		slog.Info("server shutting down", "timeout", opt.ShutdownTimeout)
+		stopServer(s, opt.ShutdownTimeout)
+		slog.Info("server shutdown completed")
+	}()
+	err = s.Serve(lis)
+	cancel()
+	<-stopped
+	if err != nil {
 		return fmt.Errorf("failed to serve: %w", err)
 	}
 	return nil
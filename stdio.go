@@ -0,0 +1,214 @@
+package grpcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	pb "github.com/fujiwara/grpcp/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stdioPath is the source to read from stdin, or the destination to write to stdout.
+const stdioPath = "-"
+
+// unknownSize is the size of the uploaded content read from a stream.
+// The actual size is sent in the last message with the checksum.
+const unknownSize = -1
+
+// uploadStdin uploads the content read from stdin to remoteFile.
+// The content is not resumable or retried, because stdin cannot be read again.
+func uploadStdin(ctx context.Context, client pb.FileTransferServiceClient, remoteFile, _ string, opt *ClientOption) error {
+	if remoteFile == "" || strings.HasSuffix(remoteFile, "/") {
+		return status.Errorf(codes.InvalidArgument, "destination filename is required to upload stdin: %s", remoteFile)
+	}
+	return uploadReader(ctx, client, remoteFile, opt.stdin(), nil, opt)
+}
+
+// uploadReader uploads the content of unknown size read from r to remoteFile.
+// The file is committed only if r reaches EOF, so closing r with an error aborts the upload.
+// With --preserve, the metadata returned by metadata after EOF is sent with the trailer.
+func uploadReader(ctx context.Context, client pb.FileTransferServiceClient, remoteFile string, r io.Reader, metadata func() *pb.FileMetadata, opt *ClientOption) error {
+	algo := checksumAlgorithm(opt.Checksum)
+	h, err := newHash(algo)
+	if err != nil {
+		return err
+	}
+	if h != nil {
+		r = io.TeeReader(r, h)
+	}
+	var compressions []string
+	if opt.Compress != "" && opt.Compress != CompressionNone {
+		if compressions, err = serverCompressions(ctx, client); err != nil {
+			return err
+		}
+	}
+	c, err := newCompressor(opt.Compress, compressions)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	limiter := newLimiter(opt.LimitRate)
+
+	stream, err := client.Upload(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to new upload stream: %w", err)
+	}
+	slog.Info("starting streaming upload", "remote", remoteFile)
+	bar := newProgressWriter(opt.Quiet, -1, "uploading")
+	totalBytes := int64(0)
+	buf := make([]byte, StreamBufferSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			req := &pb.FileUploadRequest{Filename: remoteFile, Size: unknownSize}
+			if totalBytes == 0 {
+				req.ChecksumAlgorithm = algo
+			}
+			var cerr error
+			if req.Content, req.Compression, cerr = c.compress(buf[:n]); cerr != nil {
+				return cerr
+			}
+			if err := waitLimiters(ctx, len(req.Content), limiter); err != nil {
+				return err
+			}
+			if err := stream.Send(req); err == io.EOF {
+				// the server closed the stream. the actual error is returned by CloseAndRecv
+				break
+			} else if err != nil {
+				return fmt.Errorf("failed to send file: %w", err)
+			}
+			bar.Write(buf[:n])
+			totalBytes += int64(n)
+		}
+		if err == io.EOF {
+			// send the size and the checksum as the trailer
+			req := &pb.FileUploadRequest{Filename: remoteFile, Size: totalBytes, Checksum: sum(h)}
+			if totalBytes == 0 {
+				req.ChecksumAlgorithm = algo
+			}
+			if opt.Preserve && metadata != nil {
+				req.Metadata = metadata()
+			}
+			if err := stream.Send(req); err != nil && err != io.EOF {
+				return fmt.Errorf("failed to send file: %w", err)
+			}
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read: %w", err)
+		}
+	}
+
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return fmt.Errorf("failed to receive response: %w", err)
+	}
+	slog.Info("client upload completed", "bytes", totalBytes)
+	slog.Info("server response", "message", res.Message)
+	return nil
+}
+
+// downloadStdout writes the content of remoteFile to stdout.
+// If remoteFile is a glob pattern, the matched files are concatenated like cat.
+// The content already written cannot be taken back, so the failure is reported only by the error.
+func downloadStdout(ctx context.Context, client pb.FileTransferServiceClient, remoteFile, _ string, opt *ClientOption) error {
+	w := opt.stdout()
+	if !hasGlobMeta(remoteFile) {
+		_, err := downloadTo(ctx, client, remoteFile, w, opt)
+		return err
+	}
+	var entries []*pb.GlobResponse
+	err := opt.Retry.do(ctx, func(error) (err error) {
+		entries, err = globRemote(ctx, client, remoteFile)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return status.Errorf(codes.NotFound, "no files match %s", remoteFile)
+	}
+	for _, entry := range entries {
+		if entry.IsDir {
+			slog.Warn("skipping directory", "remote", entry.Filename)
+			continue
+		}
+		if _, err := downloadTo(ctx, client, entry.Filename, w, opt); err != nil {
+			return fmt.Errorf("%s: %w", entry.Filename, err)
+		}
+	}
+	return nil
+}
+
+// downloadTo writes the content of remoteFile to w.
+// The size and the checksum are verified after all the content is written.
+// The metadata of remoteFile is returned with --preserve.
+func downloadTo(ctx context.Context, client pb.FileTransferServiceClient, remoteFile string, w io.Writer, opt *ClientOption) (*pb.FileMetadata, error) {
+	algo := checksumAlgorithm(opt.Checksum)
+	h, err := newHash(algo)
+	if err != nil {
+		return nil, err
+	}
+	if h != nil {
+		w = io.MultiWriter(w, h)
+	}
+	stream, err := client.Download(ctx, &pb.FileDownloadRequest{
+		Filename:          remoteFile,
+		ChecksumAlgorithm: algo,
+		Compression:       opt.Compress,
+		Preserve:          opt.Preserve,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to new download stream: %w", err)
+	}
+	d := &decompressor{}
+	defer d.Close()
+	limiter := newLimiter(opt.LimitRate)
+
+	slog.Info("starting streaming download", "remote", remoteFile)
+	var bar io.Writer
+	var expectedBytes int64
+	var checksum []byte
+	var metadata *pb.FileMetadata
+	totalBytes := int64(0)
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			slog.Info("client download completed", "bytes", totalBytes)
+			if totalBytes != expectedBytes {
+				return nil, status.Errorf(codes.DataLoss, "file size mismatch: expected %d bytes, got %d bytes", expectedBytes, totalBytes)
+			}
+			return metadata, verifyChecksum(h, checksum)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to receive response: %w", err)
+		}
+		if bar == nil {
+			expectedBytes = res.Size
+			bar = newProgressWriter(opt.Quiet, res.Size, "downloading")
+		}
+		if res.Checksum != nil {
+			checksum = res.Checksum
+		}
+		if res.Metadata != nil {
+			metadata = res.Metadata
+		}
+		if err := waitLimiters(ctx, len(res.Content), limiter); err != nil {
+			return nil, err
+		}
+		content, err := d.decompress(res.Content, res.Compression)
+		if err != nil {
+			return nil, err
+		}
+		if totalBytes+int64(len(content)) > expectedBytes {
+			return nil, status.Errorf(codes.DataLoss, "received more than the file size of %d bytes", expectedBytes)
+		}
+		if _, err := w.Write(content); err != nil {
+			return nil, fmt.Errorf("failed to write: %w", err)
+		}
+		bar.Write(content)
+		totalBytes += int64(len(content))
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/pem"
 	"fmt"
+	"log/slog"
 	"math/big"
+	"net"
 	"os"
 	"path/filepath"
 	"time"
@@ -23,14 +27,73 @@ func genTLS(certFile string, keyFile string) (*tls.Config, error) {
 	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
 }
 
-func genSelfSignedTLS() (*tls.Config, error) {
-	dir, err := os.MkdirTemp("", "grpcp")
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(caFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no valid certificates found in %s", caFile)
+	}
+	return pool, nil
+}
+
+func genClientTLS(opt *ClientOption, addr string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: opt.SkipVerify,
+		ServerName:         opt.ServerName,
+	}
+	if opt.SkipVerify && opt.KnownHostsFile != "" {
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyKnownHost(opt.KnownHostsFile, addr, rawCerts)
+		}
+	}
+	if opt.CACertFile != "" {
+		pool, err := loadCertPool(opt.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if opt.CertFile != "" || opt.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opt.CertFile, opt.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client key pair: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// genSelfSignedTLS generates a self-signed certificate for hosts.
+// If certDir is not empty, the generated certificate is stored in certDir and reused on the next start.
+func genSelfSignedTLS(certDir string, hosts []string) (*tls.Config, error) {
+	dir := certDir
+	if dir == "" {
+		tmpDir, err := os.MkdirTemp("", "grpcp")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp dir: %w", err)
+		}
+		defer func() {
+			os.RemoveAll(tmpDir)
+		}()
+		dir = tmpDir
+	} else {
+		certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+		if fileExists(certFile) && fileExists(keyFile) {
+			if err := checkStoredCert(certFile, hosts); err != nil {
+				slog.Warn("regenerating certificate", "cert", certFile, "reason", err)
+			} else {
+				slog.Info("loading generated certificate", "cert", certFile, "key", keyFile)
+				return genTLS(certFile, keyFile)
+			}
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create directory for certificate: %w", err)
+		}
+		slog.Info("storing generated certificate", "dir", dir)
 	}
-	defer func() {
-		os.RemoveAll(dir)
-	}()
 
 	// generate private key
 	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -39,45 +102,140 @@ func genSelfSignedTLS() (*tls.Config, error) {
 	}
 
 	// generate certificate template
-	template := x509.Certificate{
-		SerialNumber: big.NewInt(1),
-		Subject: pkix.Name{
-			Organization: []string{"My Organization"},
-		},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		BasicConstraintsValid: true,
+	commonName := "grpcp"
+	if len(hosts) > 0 {
+		commonName = hosts[0]
+	}
+	template, err := newCertTemplate(commonName, hosts, 365*24*time.Hour)
+	if err != nil {
+		return nil, err
 	}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
 
 	// generate certificate self-signed
-	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create certificate: %w", err)
 	}
 
-	// write certificate in PEM format
-	keyFile := filepath.Join(dir, "server.key")
-	keyOut, err := os.Create(keyFile)
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	if err := writeKeyPair(certFile, keyFile, certDER, priv); err != nil {
+		return nil, err
+	}
+	return genTLS(certFile, keyFile)
+}
+
+// newCertTemplate returns a certificate template with a random serial number.
+// hosts are included as subject alternative names.
+func newCertTemplate(commonName string, hosts []string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
 	if err != nil {
-		return nil, fmt.Errorf("failed to open server.key for writing: %w", err)
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"grpcp"},
+			CommonName:   commonName,
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
 	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	return template, nil
+}
+
+// writeKeyPair writes the certificate and private key in PEM format.
+// The private key file is readable only by the owner.
+func writeKeyPair(certFile, keyFile string, certDER []byte, priv *ecdsa.PrivateKey) error {
 	keyBytes, err := x509.MarshalECPrivateKey(priv)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal private: %w", err)
+		return fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", keyFile, err)
+	}
+	// os.WriteFile does not change the permission of the existing file
+	if err := os.Chmod(keyFile, 0600); err != nil {
+		return fmt.Errorf("failed to change permission of %s: %w", keyFile, err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", certFile, err)
 	}
-	pem.Encode(keyOut, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
-	keyOut.Close()
+	return nil
+}
+
+// certRenewBefore is the period before the expiration to regenerate the stored certificate.
+var certRenewBefore = 30 * 24 * time.Hour
 
-	// write certificate in PEM format
-	certFile := filepath.Join(dir, "server.crt")
-	certOut, err := os.Create(certFile)
+// checkStoredCert checks that the stored certificate is valid for hosts and not expiring.
+func checkStoredCert(certFile string, hosts []string) error {
+	b, err := os.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return fmt.Errorf("no certificate found in %s", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open server.crt for writing: %w", err)
+		return err
+	}
+	if time.Now().Add(certRenewBefore).After(cert.NotAfter) {
+		return fmt.Errorf("certificate expires at %s", cert.NotAfter)
 	}
-	pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: certDER})
-	certOut.Close()
+	for _, host := range hosts {
+		if err := cert.VerifyHostname(host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return genTLS(certFile, keyFile)
+// certHosts returns the host names and IP addresses to be included in the generated certificate.
+func certHosts(opt *ServerOption) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, host := range append([]string{opt.Listen}, opt.CertHosts...) {
+		if host == "" || seen[host] {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+			continue
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// certFingerprint returns the SHA-256 fingerprint of the DER encoded certificate.
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// DefaultConfigDir returns the directory to store the configuration files of grpcp.
+func DefaultConfigDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "grpcp")
 }
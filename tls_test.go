@@ -0,0 +1,396 @@
+package grpcp_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/grpcp"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %s", err)
+	}
+	file := filepath.Join(dir, "ca.crt")
+	writePEM(t, file, "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// issue issues a certificate signed by the CA and returns the certificate and key files.
+func (ca *testCA) issue(t *testing.T, dir, name string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP(testHost)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %s", err)
+	}
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, filename, typ string, b []byte) {
+	t.Helper()
+	if err := os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}), 0600); err != nil {
+		t.Fatalf("failed to write %s: %s", filename, err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	serverCert, serverKey := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "client", x509.ExtKeyUsageClientAuth)
+
+	port := testPortFrom + 4
+	clientOpt := &grpcp.ClientOption{
+		Host:       testHost,
+		Port:       port,
+		Quiet:      true,
+		TLS:        true,
+		SkipVerify: true,
+		CertFile:   clientCert,
+		KeyFile:    clientKey,
+	}
+	runServerWithClientOption(&grpcp.ServerOption{
+		Port:         port,
+		Listen:       testHost,
+		TLS:          true,
+		CertFile:     serverCert,
+		KeyFile:      serverKey,
+		ClientCAFile: ca.file,
+	}, clientOpt)
+
+	ctx := context.Background()
+	if _, err := grpcp.NewClient(clientOpt).Ping(ctx); err != nil {
+		t.Errorf("failed to ping with client certificate: %s", err)
+	}
+
+	// client certificates cannot be verified without TLS
+	if err := grpcp.RunServer(ctx, &grpcp.ServerOption{
+		Port:         port,
+		Listen:       testHost,
+		ClientCAFile: ca.file,
+	}); err == nil || !strings.Contains(err.Error(), "require TLS") {
+		t.Errorf("client CA without TLS: expected an error, got %v", err)
+	}
+
+	noCertOpt := *clientOpt
+	noCertOpt.CertFile, noCertOpt.KeyFile = "", ""
+	if _, err := grpcp.NewClient(&noCertOpt).Ping(ctx); err == nil {
+		t.Error("ping without client certificate must fail")
+	}
+
+	// handshake failures are not retried
+	noCertOpt.Retry = grpcp.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: time.Second, Multiplier: 2}
+	src := filepath.Join(dir, "src.txt")
+	if err := os.WriteFile(src, []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %s", err)
+	}
+	start := time.Now()
+	err := grpcp.NewClient(&noCertOpt).Copy(ctx, src, testHost+":"+filepath.Join(dir, "dest.txt"))
+	if code := grpcp.ExitCode(err); code != grpcp.ExitPermission {
+		t.Errorf("copy without client certificate: expected exit code %d, got %d: %v", grpcp.ExitPermission, code, err)
+	}
+	if msg := grpcp.ErrorMessage(err); !strings.Contains(msg, "TLS handshake failed") {
+		t.Errorf("unexpected error message: %s", msg)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("handshake failure must not be retried: took %s", elapsed)
+	}
+
+	otherCA := newTestCA(t, t.TempDir())
+	otherCert, otherKey := otherCA.issue(t, t.TempDir(), "other", x509.ExtKeyUsageClientAuth)
+	otherOpt := *clientOpt
+	otherOpt.CertFile, otherOpt.KeyFile = otherCert, otherKey
+	if _, err := grpcp.NewClient(&otherOpt).Ping(ctx); err == nil {
+		t.Error("ping with client certificate issued by other CA must fail")
+	}
+}
+
+func TestCACert(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	serverCert, serverKey := ca.issue(t, dir, "server.example.com", x509.ExtKeyUsageServerAuth)
+
+	port := testPortFrom + 5
+	clientOpt := &grpcp.ClientOption{
+		Host:       testHost,
+		Port:       port,
+		Quiet:      true,
+		TLS:        true,
+		CACertFile: ca.file,
+	}
+	runServerWithClientOption(&grpcp.ServerOption{
+		Port:     port,
+		Listen:   testHost,
+		TLS:      true,
+		CertFile: serverCert,
+		KeyFile:  serverKey,
+	}, clientOpt)
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		serverName string
+		caFile     string
+		ok         bool
+	}{
+		{serverName: "", caFile: ca.file, ok: true}, // verified by IP SAN
+		{serverName: "server.example.com", caFile: ca.file, ok: true},
+		{serverName: "wrong.example.com", caFile: ca.file, ok: false},
+		{serverName: "server.example.com", caFile: "", ok: false},
+	} {
+		opt := *clientOpt
+		opt.ServerName = tc.serverName
+		opt.CACertFile = tc.caFile
+		_, err := grpcp.NewClient(&opt).Ping(ctx)
+		if tc.ok && err != nil {
+			t.Errorf("server name %q, ca %q: failed to ping: %s", tc.serverName, tc.caFile, err)
+		} else if !tc.ok && err == nil {
+			t.Errorf("server name %q, ca %q: ping must fail", tc.serverName, tc.caFile)
+		}
+	}
+}
+
+func TestTrustOnFirstUse(t *testing.T) {
+	dir := t.TempDir()
+	knownHosts := filepath.Join(dir, "known_hosts")
+	opt := &grpcp.ClientOption{
+		Host:           testHost,
+		Port:           testPort(true),
+		Quiet:          true,
+		TLS:            true,
+		SkipVerify:     true,
+		KnownHostsFile: knownHosts,
+	}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := grpcp.NewClient(opt).Ping(ctx); err != nil {
+			t.Fatalf("failed to ping: %s", err)
+		}
+	}
+	b, err := os.ReadFile(knownHosts)
+	if err != nil {
+		t.Fatalf("failed to read known hosts: %s", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 1 {
+		t.Fatalf("unexpected known hosts: %s", string(b))
+	}
+
+	// the server certificate has changed
+	fake := fmt.Sprintf("%s:%d SHA256:AAAA\n", testHost, testPort(true))
+	if err := os.WriteFile(knownHosts, []byte(fake), 0600); err != nil {
+		t.Fatalf("failed to write known hosts: %s", err)
+	}
+	if _, err := grpcp.NewClient(opt).Ping(ctx); err == nil {
+		t.Error("ping must fail when the server certificate has changed")
+	} else if code := grpcp.ExitCode(err); code != grpcp.ExitPermission {
+		t.Errorf("certificate changed: expected exit code %d, got %d: %v", grpcp.ExitPermission, code, err)
+	}
+}
+
+func TestPersistCertificate(t *testing.T) {
+	certDir := filepath.Join(t.TempDir(), "grpcp")
+	var fingerprints []string
+	for i, port := range []int{testPortFrom + 6, testPortFrom + 7, testPortFrom + 8} {
+		opt := &grpcp.ServerOption{
+			Port:    port,
+			Listen:  testHost,
+			TLS:     true,
+			CertDir: certDir,
+		}
+		if i == 2 {
+			// the certificate is regenerated for the new host
+			opt.CertHosts = []string{"grpcp.example.com"}
+		}
+		runServerWithOption(opt)
+		knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+		_, err := grpcp.NewClient(&grpcp.ClientOption{
+			Host:           testHost,
+			Port:           port,
+			TLS:            true,
+			SkipVerify:     true,
+			KnownHostsFile: knownHosts,
+		}).Ping(context.Background())
+		if err != nil {
+			t.Fatalf("failed to ping: %s", err)
+		}
+		b, err := os.ReadFile(knownHosts)
+		if err != nil {
+			t.Fatalf("failed to read known hosts: %s", err)
+		}
+		fingerprints = append(fingerprints, strings.Fields(string(b))[1])
+	}
+	if fingerprints[0] != fingerprints[1] {
+		t.Errorf("the stored certificate is not reused: %v", fingerprints)
+	}
+	if fingerprints[1] == fingerprints[2] {
+		t.Errorf("the stored certificate is not regenerated for the new host: %v", fingerprints)
+	}
+
+	// the stored certificate includes the configured hosts, so it can be verified
+	_, err := grpcp.NewClient(&grpcp.ClientOption{
+		Host:       testHost,
+		Port:       testPortFrom + 8,
+		ServerName: "grpcp.example.com",
+		TLS:        true,
+		CACertFile: filepath.Join(certDir, "server.crt"),
+	}).Ping(context.Background())
+	if err != nil {
+		t.Errorf("failed to verify the stored certificate: %s", err)
+	}
+	st, err := os.Stat(filepath.Join(certDir, "server.key"))
+	if err != nil {
+		t.Fatalf("failed to stat the stored key: %s", err)
+	}
+	if st.Mode().Perm() != 0600 {
+		t.Errorf("unexpected permission of the stored key: %o", st.Mode().Perm())
+	}
+	if st, err := os.Stat(certDir); err != nil || st.Mode().Perm() != 0700 {
+		t.Errorf("unexpected permission of the certificate directory: %v", err)
+	}
+}
+
+func TestReloadCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	certFile, keyFile := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+
+	port := testPortFrom + 9
+	runServerWithOption(&grpcp.ServerOption{
+		Port:               port,
+		Listen:             testHost,
+		TLS:                true,
+		CertFile:           certFile,
+		KeyFile:            keyFile,
+		CertReloadInterval: 100 * time.Millisecond,
+	})
+	fingerprint := func() string {
+		t.Helper()
+		knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+		_, err := grpcp.NewClient(&grpcp.ClientOption{
+			Host:           testHost,
+			Port:           port,
+			TLS:            true,
+			SkipVerify:     true,
+			KnownHostsFile: knownHosts,
+		}).Ping(context.Background())
+		if err != nil {
+			t.Fatalf("failed to ping: %s", err)
+		}
+		b, err := os.ReadFile(knownHosts)
+		if err != nil {
+			t.Fatalf("failed to read known hosts: %s", err)
+		}
+		return strings.Fields(string(b))[1]
+	}
+
+	before := fingerprint()
+	// replace the certificate
+	ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	future := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, future, future); err != nil {
+			t.Fatalf("failed to change times: %s", err)
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+	if after := fingerprint(); before == after {
+		t.Errorf("the certificate is not reloaded: %s", after)
+	}
+}
+
+func TestIssueCertificates(t *testing.T) {
+	dir := t.TempDir()
+	validity := 24 * time.Hour
+	if err := grpcp.InitCA(dir, "test CA", validity, false); err != nil {
+		t.Fatalf("failed to init CA: %s", err)
+	}
+	if err := grpcp.InitCA(dir, "test CA", validity, false); err == nil {
+		t.Error("init CA must fail when the files already exist")
+	}
+	if err := grpcp.IssueServerCert(dir, "server", []string{testHost}, validity, false); err != nil {
+		t.Fatalf("failed to issue server certificate: %s", err)
+	}
+	if err := grpcp.IssueClientCert(dir, "alice", validity, false); err != nil {
+		t.Fatalf("failed to issue client certificate: %s", err)
+	}
+
+	port := testPortFrom + 10
+	clientOpt := &grpcp.ClientOption{
+		Host:       testHost,
+		Port:       port,
+		TLS:        true,
+		CACertFile: filepath.Join(dir, "ca.crt"),
+		CertFile:   filepath.Join(dir, "alice.crt"),
+		KeyFile:    filepath.Join(dir, "alice.key"),
+	}
+	runServerWithClientOption(&grpcp.ServerOption{
+		Port:         port,
+		Listen:       testHost,
+		TLS:          true,
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	}, clientOpt)
+	if _, err := grpcp.NewClient(clientOpt).Ping(context.Background()); err != nil {
+		t.Errorf("failed to ping with the issued certificates: %s", err)
+	}
+}